@@ -1,11 +1,16 @@
 package main
 
 import (
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/bearer"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/headerInjection"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/oidc"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/page"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/upstream"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/userinfo"
 )
 
 type Config struct {
@@ -13,6 +18,11 @@ type Config struct {
 	Upstream        upstream.Config
 	HeaderInjection headerInjection.Config
 	ProxyURL        proxyURL.Config
+	Redirect        redirect.Config
 	Log             log.Config
+	Bearer          bearer.Config
+	Page            page.Config
+	I18N            i18n.Config
+	UserInfo        userinfo.Config
 	Port            int
 }
@@ -4,11 +4,16 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/bearer"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/headerInjection"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/oidc"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/page"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/upstream"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/userinfo"
 )
 
 type ConfigSchema struct {
@@ -16,7 +21,12 @@ type ConfigSchema struct {
 	Upstream        upstream.ConfigSchema        `json:"upstream"`
 	HeaderInjection headerInjection.ConfigSchema `json:"headerInjection"`
 	ProxyURL        proxyURL.ConfigSchema        `json:"proxyURL"`
+	Redirect        redirect.ConfigSchema        `json:"redirect"`
 	Log             log.ConfigSchema             `json:"log"`
+	Bearer          bearer.ConfigSchema          `json:"bearer"`
+	Page            page.ConfigSchema            `json:"page"`
+	I18N            i18n.ConfigSchema            `json:"i18n"`
+	UserInfo        userinfo.ConfigSchema        `json:"userinfo"`
 	Port            int                          `json:"port" env:"OAUTH2PROXY_PORT"`
 }
 
@@ -39,10 +49,30 @@ func (s *ConfigSchema) Validate() error {
 		errMessages = append(errMessages, err.Error())
 	}
 
+	if err := s.Redirect.Validate(); err != nil {
+		errMessages = append(errMessages, err.Error())
+	}
+
 	if err := s.Log.Validate(); err != nil {
 		errMessages = append(errMessages, err.Error())
 	}
 
+	if err := s.Bearer.Validate(); err != nil {
+		errMessages = append(errMessages, err.Error())
+	}
+
+	if err := s.Page.Validate(); err != nil {
+		errMessages = append(errMessages, err.Error())
+	}
+
+	if err := s.I18N.Validate(); err != nil {
+		errMessages = append(errMessages, err.Error())
+	}
+
+	if err := s.UserInfo.Validate(); err != nil {
+		errMessages = append(errMessages, err.Error())
+	}
+
 	if !isValidPort(s.Port) {
 		errMessages = append(errMessages, "error: port number is invalid")
 	}
@@ -63,7 +93,12 @@ func (s *ConfigSchema) CreateConfig() any {
 		Upstream:        s.Upstream.CreateConfig(),
 		HeaderInjection: s.HeaderInjection.CreateConfig(),
 		ProxyURL:        s.ProxyURL.CreateConfig(),
+		Redirect:        s.Redirect.CreateConfig(),
 		Log:             s.Log.CreateConfig(),
+		Bearer:          s.Bearer.CreateConfig(),
+		Page:            s.Page.CreateConfig(),
+		I18N:            s.I18N.CreateConfig(),
+		UserInfo:        s.UserInfo.CreateConfig(),
 		Port:            s.Port,
 	}
 }
@@ -5,12 +5,16 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/bearer"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/config"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/forwardAuth"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/headerInjection"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/health"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/login"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/oidc"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/page"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/ready"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
@@ -18,6 +22,7 @@ import (
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/upstream"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/userinfo"
 )
 
 func main() {
@@ -28,21 +33,32 @@ func StartOAuth2Proxy() {
 	c := config.LoadConfig(&ConfigSchema{}).(Config)
 	headerInjectMiddleware := headerInjection.CreateMiddleware(c.HeaderInjection)
 	proxyURL.Init(c.ProxyURL)
+	redirect.Init(c.Redirect)
+	page.Init(c.Page)
+	i18n.Init(c.I18N)
 	session.Init()
 	log.Init(c.Log)
+	oidc.StartDiscovery(c.OIDC)
 
 	r := chi.NewRouter()
 	r.Use(log.CreateLoggerMiddleware)
 	r.Use(requestid.AddIDMiddleware)
+	r.Use(i18n.NegotiateMiddleware)
 	r.Use(sessionid.LoadMiddleware)
+	r.Use(oidc.NewRefreshMiddleware(c.OIDC))
+	r.Use(bearer.CreateMiddleware(c.Bearer, oidc.NewBearerTokenVerifier(c.OIDC)))
 	r.Use(login.GetLoginStatusMiddleware)
 	r.Use(redirect.GetMiddleware)
 	health.AddEndpoint(r)
-	ready.AddEndpoint(r)
+	ready.AddEndpoint(r, oidc.NewReadinessCheck(c.OIDC))
+	page.AddStaticEndpoint(r)
+	userinfo.AddEndpoint(r, c.UserInfo)
+	forwardAuth.AddEndpoint(r, c.HeaderInjection, oidc.NewUnauthorizedHandler(c.OIDC))
+	forwardAuth.AddExtAuthzEndpoint(r, c.HeaderInjection, oidc.NewSignInRedirectHandler(c.OIDC))
 	oidcRouter := oidc.NewRouter(c.OIDC)
 	r.Mount(oidc.Path, oidcRouter)
 
-	upstreamRouter := upstream.NewRouter(c.Upstream)
+	upstreamRouter := upstream.NewRouter(c.Upstream, redirect.FindMiddleware(oidc.NewStepUpHandler(c.OIDC)), oidc.NewForbiddenHandler(c.OIDC))
 	loginHandler := oidc.NewLoginHandler(c.OIDC)
 
 	r.HandleFunc("/*", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,79 @@
+package bearer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+)
+
+// 検証に成功したベアラートークンが格納される
+type Key struct{}
+
+type Token struct {
+	ProviderID string
+	IDToken    *oidc.IDToken
+}
+
+// トークンの署名、issおよびexpを検証し、発行したプロバイダーのIDを返す
+// audの検証はこのパッケージで行うため、ここでは行わなくてよい
+type VerifyFunc func(ctx context.Context, rawToken string) (string, *oidc.IDToken, error)
+
+func CreateMiddleware(config Config, verify VerifyFunc) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rawToken, found := getBearerToken(r.Header)
+			if !config.Enabled || !found {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+			logger.Debug().Msg("Verifying bearer token")
+
+			providerID, idToken, err := verify(r.Context(), rawToken)
+			if err == nil && !hasAcceptedAudience(config, idToken) {
+				err = errors.New("error: token audience is not accepted")
+			}
+			if err != nil {
+				// トークンが送られてきたのに検証できない場合は、Cookieのセッションにフォールバックせずに拒否する
+				logger.Warn().Err(err).Msg("Invalid bearer token")
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				i18n.Error(w, r, "error.invalidBearerToken", http.StatusUnauthorized)
+				return
+			}
+
+			*logger = logger.With().Str("bearerProviderID", providerID).Logger()
+			logger.Debug().Msg("Bearer token was verified")
+			ctx := context.WithValue(r.Context(), Key{}, Token{
+				ProviderID: providerID,
+				IDToken:    idToken,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func getBearerToken(header http.Header) (string, bool) {
+	authorization := header.Get("Authorization")
+	scheme, token, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func hasAcceptedAudience(config Config, idToken *oidc.IDToken) bool {
+	for _, aud := range idToken.Audience {
+		if slices.Contains(config.Audiences, aud) {
+			return true
+		}
+	}
+	return false
+}
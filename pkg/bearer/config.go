@@ -0,0 +1,6 @@
+package bearer
+
+type Config struct {
+	Enabled   bool
+	Audiences []string
+}
@@ -0,0 +1,26 @@
+package bearer
+
+import "errors"
+
+type ConfigSchema struct {
+	// trueの場合、AuthorizationヘッダーのBearerトークンによる認証を受け付ける
+	Enabled bool `json:"enabled"`
+
+	// 受け付けるトークンのaudの値。いずれか1つでも含まれていればよい
+	Audiences []string `json:"audiences"`
+}
+
+func (s *ConfigSchema) Validate() error {
+	// audを検証しないと、同じIdPが別のサービス向けに発行したトークンも受け付けてしまう
+	if s.Enabled && len(s.Audiences) == 0 {
+		return errors.New("error: at least one audience is required for bearer token authentication")
+	}
+	return nil
+}
+
+func (s *ConfigSchema) CreateConfig() Config {
+	return Config{
+		Enabled:   s.Enabled,
+		Audiences: s.Audiences,
+	}
+}
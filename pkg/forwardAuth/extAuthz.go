@@ -0,0 +1,57 @@
+package forwardAuth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/headerInjection"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
+)
+
+// Envoyのext_authzのpath_prefixに指定するパス
+const extAuthzPath string = "/oauth2/ext_authz"
+
+// Envoyのext_authz(HTTPサービス)から呼ばれ、元のリクエストのヘッダーでログイン状態を確認する
+// Envoyは200の場合のみリクエストを許可するため、ログインしている場合は200で、Upstreamに付与するヘッダーを返す
+// 200以外のレスポンスはEnvoyがそのままクライアントに返すため、ログインしていない場合はunauthorizedHandlerが302か401を返す必要がある
+func AddExtAuthzEndpoint(r *chi.Mux, config headerInjection.Config, unauthorizedHandler http.Handler) {
+	handler := extAuthzRedirectMiddleware(createHandler(config, http.StatusOK, unauthorizedHandler))
+	r.Handle(extAuthzPath, handler)
+	r.Handle(extAuthzPath+"/*", handler)
+}
+
+// Envoyは元のリクエストのパスをpath_prefixの後ろに付け、Hostはそのまま送るため、ここから元のURLを復元する
+func extAuthzRedirectMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+
+		originalURL := url.URL{
+			Scheme:   r.Header.Get("X-Forwarded-Proto"),
+			Host:     r.Host,
+			Path:     strings.TrimPrefix(r.URL.Path, extAuthzPath),
+			RawQuery: r.URL.RawQuery,
+		}
+		if originalURL.Scheme == "" {
+			originalURL.Scheme = "https"
+		}
+		if originalURL.Path == "" {
+			originalURL.Path = "/"
+		}
+
+		// Hostとx-forwarded-protoはクライアントが指定できるため、他のリダイレクトURLと同じく許可されたホストのみを受け付ける
+		redirectURL := originalURL.String()
+		if !redirect.IsValidURL(redirectURL) {
+			logger.Warn().Str("url", redirectURL).Msg("ext_authz redirect URL is not allowed. Ignoring it.")
+			redirectURL = ""
+		}
+
+		*logger = logger.With().Str("ext_authz redirect URL", redirectURL).Logger()
+		ctx := context.WithValue(r.Context(), redirect.Key{}, redirectURL)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,53 @@
+package forwardAuth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
+)
+
+func TestExtAuthzRedirectMiddleware(t *testing.T) {
+	proxyURL.Init(proxyURL.Config{Host: "proxy.example.com"})
+	redirect.Init(redirect.Config{AllowedHosts: []string{"app.example.com"}})
+
+	tests := []struct {
+		name    string
+		target  string
+		host    string
+		headers map[string]string
+		want    string
+	}{
+		{"allowed host", "/oauth2/ext_authz/x?y=1", "app.example.com", nil, "https://app.example.com/x?y=1"},
+		{"root path", "/oauth2/ext_authz", "app.example.com", nil, "https://app.example.com/"},
+		{"forwarded proto", "/oauth2/ext_authz/x", "app.example.com", map[string]string{"X-Forwarded-Proto": "http"}, "http://app.example.com/x"},
+		{"disallowed host", "/oauth2/ext_authz/x", "evil.com", nil, ""},
+		{"disallowed proto", "/oauth2/ext_authz/x", "app.example.com", map[string]string{"X-Forwarded-Proto": "javascript"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Host = tt.host
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			logger := zerolog.Nop()
+			r = r.WithContext(context.WithValue(r.Context(), log.Key{}, &logger))
+
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Context().Value(redirect.Key{}).(string)
+			})
+			extAuthzRedirectMiddleware(next).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("redirect URL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
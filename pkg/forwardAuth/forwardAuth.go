@@ -0,0 +1,50 @@
+package forwardAuth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/headerInjection"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/login"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
+)
+
+const path string = "/oauth2/auth"
+
+// nginxのauth_request、TraefikのForwardAuthおよびCaddyのforward_authから呼ばれ、プロキシは行わずにログイン状態のみを返す
+// ログインしている場合は、Upstreamへのリクエストに付与するヘッダーをレスポンスヘッダーとして202で返す
+// ログインしていない場合はunauthorizedHandlerに任せる
+func AddEndpoint(r *chi.Mux, config headerInjection.Config, unauthorizedHandler http.Handler) {
+	r.Handle(path, redirect.ForwardedMiddleware(createHandler(config, http.StatusAccepted, unauthorizedHandler)))
+}
+
+func createHandler(config headerInjection.Config, status int, unauthorizedHandler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+
+		isLogin := r.Context().Value(login.Key{}).(bool)
+		if !isLogin {
+			logger.Debug().Msg("Forward auth request without login")
+			unauthorizedHandler.ServeHTTP(w, r)
+			return
+		}
+
+		ident := r.Context().Value(identity.Key{}).(*identity.Identity)
+		header, err := headerInjection.GetRequestHeaders(config, ident)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to get headers for forward auth response")
+			i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
+			return
+		}
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+
+		logger.Debug().Msg("Forward auth request is authenticated")
+		w.WriteHeader(status)
+	}
+}
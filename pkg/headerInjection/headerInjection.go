@@ -5,24 +5,39 @@ import (
 	"net/http"
 
 	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
-	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
 )
 
+// フォワード認証でリバースプロキシに返すために、Upstreamへのリクエストに付与するヘッダーを計算する
+func GetRequestHeaders(config Config, ident *identity.Identity) (http.Header, error) {
+	header := http.Header{}
+	for _, injector := range config.Request {
+		key := injector.GetKey()
+		value, err := injector.GetValue(ident)
+		if err != nil {
+			return nil, fmt.Errorf("error: failed to set request header '%s': %w", key, err)
+		}
+		header.Set(key, value)
+	}
+	return header, nil
+}
+
 func CreateMiddleware(config Config) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
-			id := r.Context().Value(sessionid.Key{}).(sessionid.ID)
+			ident := r.Context().Value(identity.Key{}).(*identity.Identity)
 
 			logger.Debug().Msg("Injecting header of upstream request")
 
 			for _, injector := range config.Request {
 				key := injector.GetKey()
-				value, err := injector.GetValue(id)
+				value, err := injector.GetValue(ident)
 				if err != nil {
 					logger.Error().Str("headerKey", key).Err(err).Msg("Failed to set request header")
-					http.Error(w, fmt.Sprintf("Error setting request header '%s': %v", key, err), http.StatusInternalServerError)
+					i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
 					return
 				}
 				r.Header.Set(key, value)
@@ -33,10 +48,10 @@ func CreateMiddleware(config Config) func(next http.Handler) http.Handler {
 
 			for _, injector := range config.Response {
 				key := injector.GetKey()
-				value, err := injector.GetValue(id)
+				value, err := injector.GetValue(ident)
 				if err != nil {
 					logger.Error().Str("headerKey", key).Err(err).Msg("Failed to set response header")
-					http.Error(w, fmt.Sprintf("Error setting response header '%s': %v", key, err), http.StatusInternalServerError)
+					i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
 					return
 				}
 				w.Header().Set(key, value)
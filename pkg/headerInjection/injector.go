@@ -3,13 +3,12 @@ package headerInjection
 import (
 	"fmt"
 
-	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
-	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
 )
 
 type headerInjector interface {
 	GetKey() string
-	GetValue(id sessionid.ID) (string, error)
+	GetValue(ident *identity.Identity) (string, error)
 }
 
 type idTokenInjector struct {
@@ -26,13 +25,9 @@ func (injector *idTokenInjector) GetKey() string {
 	return injector.Name
 }
 
-func (injector *idTokenInjector) GetValue(id sessionid.ID) (string, error) {
-	idToken, err := session.GetIDToken(id)
-	if err != nil {
-		return "", err
-	}
+func (injector *idTokenInjector) GetValue(ident *identity.Identity) (string, error) {
 	var tokenClaims claims
-	if err := idToken.Claims(&tokenClaims); err != nil {
+	if err := ident.IDTokenClaims.Claims(&tokenClaims); err != nil {
 		return "", err
 	}
 	for _, claim := range injector.Claims {
@@ -47,13 +42,9 @@ func (injector *userInfoInjector) GetKey() string {
 	return injector.Name
 }
 
-func (injector *userInfoInjector) GetValue(id sessionid.ID) (string, error) {
-	userinfo, err := session.GetUserInfo(id)
-	if err != nil {
-		return "", err
-	}
+func (injector *userInfoInjector) GetValue(ident *identity.Identity) (string, error) {
 	var userinfoClaims claims
-	if err := userinfo.Claims(&userinfoClaims); err != nil {
+	if err := ident.UserInfoClaims.Claims(&userinfoClaims); err != nil {
 		return "", err
 	}
 	for _, claim := range injector.Claims {
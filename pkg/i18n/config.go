@@ -0,0 +1,6 @@
+package i18n
+
+type Config struct {
+	CatalogDir      string
+	DefaultLanguage string
+}
@@ -0,0 +1,47 @@
+package i18n
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type ConfigSchema struct {
+	// <言語>.jsonという名前のメッセージカタログを置くディレクトリ
+	// 組み込みのカタログと同じ言語の場合は、同じキーのメッセージのみを上書きする
+	CatalogDir string `json:"catalogDir"`
+
+	// Accept-Languageに対応する言語が無い場合に使う言語。省略した場合は"en"
+	DefaultLanguage string `json:"defaultLanguage"`
+}
+
+const fallbackLanguage string = "en"
+
+func (s *ConfigSchema) Validate() error {
+	errMessages := make([]string, 0)
+
+	catalogs, err := loadCatalogs(s.CatalogDir)
+	if err != nil {
+		errMessages = append(errMessages, fmt.Sprintf("error: failed to load message catalogs: %s", err.Error()))
+	} else if s.DefaultLanguage != "" {
+		if _, exists := catalogs[strings.ToLower(s.DefaultLanguage)]; !exists {
+			errMessages = append(errMessages, fmt.Sprintf("error: no message catalog for defaultLanguage: %s", s.DefaultLanguage))
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
+func (s *ConfigSchema) CreateConfig() Config {
+	defaultLanguage := strings.ToLower(s.DefaultLanguage)
+	if defaultLanguage == "" {
+		defaultLanguage = fallbackLanguage
+	}
+	return Config{
+		CatalogDir:      s.CatalogDir,
+		DefaultLanguage: defaultLanguage,
+	}
+}
@@ -0,0 +1,186 @@
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed catalogs/*.json
+var embeddedCatalogs embed.FS
+
+// Contextには、Accept-Languageから決めた言語が格納される
+type Key struct{}
+
+type catalog map[string]string
+
+var config Config
+
+// 言語(小文字)からカタログへの対応。起動時に一度だけ読み込む
+var catalogs map[string]catalog
+
+func Init(c Config) {
+	config = c
+	loaded, err := loadCatalogs(c.CatalogDir)
+	if err != nil {
+		panic(err)
+	}
+	catalogs = loaded
+}
+
+// 組み込みのカタログを読み込んだ後、カタログのディレクトリにあるファイルで上書きする
+func loadCatalogs(dir string) (map[string]catalog, error) {
+	loaded := make(map[string]catalog)
+	entries, err := embeddedCatalogs.ReadDir("catalogs")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		data, err := embeddedCatalogs.ReadFile("catalogs/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := mergeCatalog(loaded, entry.Name(), data); err != nil {
+			return nil, err
+		}
+	}
+	if dir == "" {
+		return loaded, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := mergeCatalog(loaded, filepath.Base(path), data); err != nil {
+			return nil, err
+		}
+	}
+	return loaded, nil
+}
+
+func mergeCatalog(catalogs map[string]catalog, fileName string, data []byte) error {
+	var messages catalog
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return fmt.Errorf("error: %s: %s", fileName, err.Error())
+	}
+	language := strings.ToLower(strings.TrimSuffix(fileName, ".json"))
+	if _, exists := catalogs[language]; !exists {
+		catalogs[language] = make(catalog)
+	}
+	for key, message := range messages {
+		catalogs[language][key] = message
+	}
+	return nil
+}
+
+// Accept-Languageから、カタログのある言語の中で最も優先度の高いものを選んでContextに格納する
+func NegotiateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		language := negotiate(r.Header.Get("Accept-Language"))
+		ctx := context.WithValue(r.Context(), Key{}, language)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type languageRange struct {
+	tag     string
+	quality float64
+}
+
+func negotiate(acceptLanguage string) string {
+	ranges := make([]languageRange, 0)
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+		quality := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > 0 {
+			ranges = append(ranges, languageRange{tag: strings.ToLower(tag), quality: quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+
+	for _, lr := range ranges {
+		// "ja-JP"のカタログが無ければ"ja"のカタログを使う
+		for tag := lr.tag; tag != ""; {
+			if _, exists := catalogs[tag]; exists {
+				return tag
+			}
+			i := strings.LastIndex(tag, "-")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return config.DefaultLanguage
+}
+
+// 指定した言語のメッセージを返す
+// 見つからない場合はデフォルトの言語、それも無い場合はキーをそのまま返す
+func Translate(language string, key string, args ...any) string {
+	message, found := lookup(language, key)
+	if !found {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+func lookup(language string, key string) (string, bool) {
+	if message, found := catalogs[language][key]; found {
+		return message, true
+	}
+	message, found := catalogs[config.DefaultLanguage][key]
+	return message, found
+}
+
+// リクエストの言語のメッセージを返す
+func Message(r *http.Request, key string, args ...any) string {
+	return Translate(Language(r), key, args...)
+}
+
+func Language(r *http.Request) string {
+	if language, ok := r.Context().Value(Key{}).(string); ok {
+		return language
+	}
+	return config.DefaultLanguage
+}
+
+// http.Errorと同じく、リクエストの言語のメッセージをテキストで返す
+func Error(w http.ResponseWriter, r *http.Request, key string, status int, args ...any) {
+	http.Error(w, Message(r, key, args...), status)
+}
+
+// カタログに"status.<コード>"が無い場合は、http.StatusTextを返す
+func StatusText(language string, status int) string {
+	if message, found := lookup(language, fmt.Sprintf("status.%d", status)); found {
+		return message
+	}
+	return http.StatusText(status)
+}
@@ -0,0 +1,109 @@
+package i18n
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	Init(Config{DefaultLanguage: "en"})
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		want           string
+	}{
+		{"empty", "", "en"},
+		{"exact", "ja", "ja"},
+		{"region falls back to language", "ja-JP", "ja"},
+		{"case insensitive", "JA-jp", "ja"},
+		{"unsupported language", "fr-FR, de", "en"},
+		{"first supported language", "fr, ja;q=0.5", "ja"},
+		{"quality order", "en;q=0.5, ja;q=0.8", "ja"},
+		{"implicit quality is highest", "en;q=0.9, ja", "ja"},
+		{"zero quality is excluded", "ja;q=0, en;q=0.1", "en"},
+		{"invalid quality is ignored", "ja;q=abc, en", "en"},
+		{"wildcard", "*", "en"},
+		{"spaces", " ja-JP ; q=0.9 , en ; q=0.8", "ja"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiate(tt.acceptLanguage); got != tt.want {
+				t.Errorf("negotiate(%q) = %q, want %q", tt.acceptLanguage, got, tt.want)
+			}
+		})
+	}
+}
+
+// 翻訳によってメッセージが欠けたり、引数の数が変わったりしていないことを確認する
+func TestEmbeddedCatalogsAreConsistent(t *testing.T) {
+	loaded, err := loadCatalogs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	en := loaded["en"]
+	for language, c := range loaded {
+		for key, message := range en {
+			translated, found := c[key]
+			if !found {
+				t.Errorf("%s catalog does not have %s", language, key)
+				continue
+			}
+			if strings.Count(translated, "%") != strings.Count(message, "%") {
+				t.Errorf("%s catalog has different arguments for %s", language, key)
+			}
+		}
+		for key := range c {
+			if _, found := en[key]; !found {
+				t.Errorf("%s catalog has unknown key %s", language, key)
+			}
+		}
+	}
+}
+
+// エラーの詳細を利用者に見せないよう、エラーを引数に取るメッセージを置かない
+func TestCallbackErrorMessagesHaveNoArguments(t *testing.T) {
+	loaded, err := loadCatalogs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"error.profileFailed", "error.idTokenInvalid", "error.userInfoFailed", "error.sessionRefreshFailed"}
+	for language, c := range loaded {
+		for _, key := range keys {
+			if strings.Contains(c[key], "%") {
+				t.Errorf("%s catalog message %s has an argument: %q", language, key, c[key])
+			}
+		}
+	}
+}
+
+// ソースコードで使われている全てのキーが、カタログに存在することを確認する
+func TestUsedKeysExistInCatalogs(t *testing.T) {
+	loaded, err := loadCatalogs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern := regexp.MustCompile(`i18n\.(?:Error|Message)\([^"]*"([^"]+)"`)
+	err = filepath.WalkDir("../..", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, match := range pattern.FindAllStringSubmatch(string(source), -1) {
+			if _, found := loaded["en"][match[1]]; !found {
+				t.Errorf("%s uses unknown key %s", path, match[1])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
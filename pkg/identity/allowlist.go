@@ -0,0 +1,119 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Upstreamにアクセスできるユーザーの条件
+// メールアドレスとグループの両方を指定した場合は、両方を満たす必要がある
+// ゼロ値は全てのユーザーを許可する
+type Allowlist struct {
+	// メールアドレスがいずれかに完全一致するか、いずれかのドメインに属する必要がある
+	// 大文字と小文字を区別しないため、小文字で指定する
+	Emails       []string
+	EmailDomains []string
+
+	// trueの場合、email_verifiedがtrueでないメールアドレスでも照合する
+	// email_verifiedを返さないOAuth2のプロバイダーのために使う
+	AllowUnverifiedEmail bool
+
+	// GroupsClaimのパスにあるクレームの値が、いずれかのグループに一致する必要がある
+	GroupsClaim string
+	Groups      []string
+}
+
+func (a Allowlist) IsEmpty() bool {
+	return !a.hasEmailCondition() && !a.hasGroupCondition()
+}
+
+func (a Allowlist) hasEmailCondition() bool {
+	return len(a.Emails) > 0 || len(a.EmailDomains) > 0
+}
+
+func (a Allowlist) hasGroupCondition() bool {
+	return len(a.Groups) > 0
+}
+
+// クレームが条件を満たさない場合は、その理由をエラーとして返す
+func (a Allowlist) Check(claims map[string]any) error {
+	errMessages := make([]string, 0)
+
+	if a.hasEmailCondition() {
+		if err := a.checkEmail(claims); err != nil {
+			errMessages = append(errMessages, err.Error())
+		}
+	}
+
+	if a.hasGroupCondition() {
+		groups := GetStrings(claims, a.GroupsClaim)
+		if !slices.ContainsFunc(groups, func(group string) bool { return slices.Contains(a.Groups, group) }) {
+			errMessages = append(errMessages, fmt.Sprintf("error: user does not belong to allowed groups: %v", groups))
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
+func (a Allowlist) checkEmail(claims map[string]any) error {
+	email, _ := claims["email"].(string)
+	email = strings.ToLower(email)
+	if email == "" {
+		return errors.New("error: email claim is missing")
+	}
+	if !a.AllowUnverifiedEmail && !isEmailVerified(claims) {
+		return fmt.Errorf("error: email is not verified: %s", email)
+	}
+	if slices.Contains(a.Emails, email) {
+		return nil
+	}
+	if at := strings.LastIndex(email, "@"); at >= 0 && slices.Contains(a.EmailDomains, email[at+1:]) {
+		return nil
+	}
+	return fmt.Errorf("error: email is not allowed: %s", email)
+}
+
+// 一部のIdPはemail_verifiedを文字列で返す
+func isEmailVerified(claims map[string]any) bool {
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	}
+	return false
+}
+
+// RFC 9068のアクセストークンはスペース区切りのscopeを、一部のIdPは配列のscpを使う
+func GetScopes(claims map[string]any) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	return GetStrings(claims, "scp")
+}
+
+// グループやロールのクレームは、IdPによって文字列の配列の場合と単一の文字列の場合がある
+func GetStrings(claims map[string]any, path string) []string {
+	value, found := Lookup(claims, path)
+	if !found {
+		return nil
+	}
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0)
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
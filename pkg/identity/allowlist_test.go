@@ -0,0 +1,103 @@
+package identity
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAllowlistCheck(t *testing.T) {
+	emailList := Allowlist{
+		Emails:       []string{"alice@partner.example"},
+		EmailDomains: []string{"example.com"},
+	}
+	groupList := Allowlist{
+		GroupsClaim: "realm_access.roles",
+		Groups:      []string{"admins"},
+	}
+	bothList := Allowlist{
+		EmailDomains: []string{"example.com"},
+		GroupsClaim:  "groups",
+		Groups:       []string{"admins"},
+	}
+
+	tests := []struct {
+		name      string
+		allowlist Allowlist
+		claims    map[string]any
+		wantErr   bool
+	}{
+		{"empty allowlist", Allowlist{}, map[string]any{}, false},
+		{"allowed email", emailList, map[string]any{"email": "alice@partner.example", "email_verified": true}, false},
+		{"email is case insensitive", emailList, map[string]any{"email": "Alice@Partner.Example", "email_verified": true}, false},
+		{"allowed domain", emailList, map[string]any{"email": "bob@example.com", "email_verified": true}, false},
+		{"subdomain is not allowed", emailList, map[string]any{"email": "bob@sub.example.com", "email_verified": true}, true},
+		{"domain suffix is not allowed", emailList, map[string]any{"email": "bob@evilexample.com", "email_verified": true}, true},
+		{"other email", emailList, map[string]any{"email": "eve@partner.example", "email_verified": true}, true},
+		{"email verified as string", emailList, map[string]any{"email": "bob@example.com", "email_verified": "true"}, false},
+		{"unverified email", emailList, map[string]any{"email": "bob@example.com", "email_verified": false}, true},
+		{"missing email_verified", emailList, map[string]any{"email": "bob@example.com"}, true},
+		{"unverified email is allowed", Allowlist{EmailDomains: []string{"example.com"}, AllowUnverifiedEmail: true}, map[string]any{"email": "bob@example.com"}, false},
+		{"missing email", emailList, map[string]any{"email_verified": true}, true},
+		{"allowed nested group", groupList, map[string]any{"realm_access": map[string]any{"roles": []any{"users", "admins"}}}, false},
+		{"other nested group", groupList, map[string]any{"realm_access": map[string]any{"roles": []any{"users"}}}, true},
+		{"missing group claim", groupList, map[string]any{}, true},
+		{"group as single string", Allowlist{GroupsClaim: "groups", Groups: []string{"admins"}}, map[string]any{"groups": "admins"}, false},
+		{"both conditions", bothList, map[string]any{"email": "bob@example.com", "email_verified": true, "groups": []any{"admins"}}, false},
+		{"only email condition", bothList, map[string]any{"email": "bob@example.com", "email_verified": true, "groups": []any{"users"}}, true},
+		{"only group condition", bothList, map[string]any{"email": "eve@evil.example", "email_verified": true, "groups": []any{"admins"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.allowlist.Check(tt.claims)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetStrings(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		path   string
+		want   []string
+	}{
+		{"array", map[string]any{"groups": []any{"a", "b"}}, "groups", []string{"a", "b"}},
+		{"single string", map[string]any{"groups": "a"}, "groups", []string{"a"}},
+		{"non string items are skipped", map[string]any{"groups": []any{"a", 1.0, true}}, "groups", []string{"a"}},
+		{"nested", map[string]any{"realm_access": map[string]any{"roles": []any{"a"}}}, "realm_access.roles", []string{"a"}},
+		{"missing", map[string]any{}, "groups", nil},
+		{"number", map[string]any{"groups": 1.0}, "groups", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetStrings(tt.claims, tt.path); !slices.Equal(got, tt.want) {
+				t.Errorf("GetStrings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   []string
+	}{
+		{"space separated scope", map[string]any{"scope": "openid  api:read"}, []string{"openid", "api:read"}},
+		{"scp array", map[string]any{"scp": []any{"api:read", "api:write"}}, []string{"api:read", "api:write"}},
+		{"scope takes precedence", map[string]any{"scope": "a", "scp": []any{"b"}}, []string{"a"}},
+		{"missing", map[string]any{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetScopes(tt.claims); !slices.Equal(got, tt.want) {
+				t.Errorf("GetScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
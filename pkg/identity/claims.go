@@ -0,0 +1,29 @@
+package identity
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// IDトークンを持たないOAuth2のプロバイダーで、プロフィールから組み立てたクレームを表す
+type JSONClaims []byte
+
+func (c JSONClaims) Claims(v any) error {
+	return json.Unmarshal(c, v)
+}
+
+// "realm_access.roles"のようにドットで区切られたパスで、ネストしたクレームの値を取り出す
+func Lookup(claims map[string]any, path string) (any, bool) {
+	var current any = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,41 @@
+package identity
+
+// ログイン中のユーザーを表す情報
+// Cookieのセッションとベアラートークンのどちらで認証されたかに関わらず、同じ形で後続の処理に渡すために使う
+type Identity struct {
+	ProviderID string
+
+	// IDトークンのクレーム。ベアラートークンの場合はそのJWTのクレーム
+	IDTokenClaims Claims
+
+	// UserInfoのクレーム。UserInfoを取得していないベアラートークンの場合はJWTのクレーム
+	UserInfoClaims Claims
+
+	// 許可されたスコープ。Cookieのセッションではトークンレスポンスのscope、ベアラートークンではJWTのscopeまたはscp
+	Scopes []string
+}
+
+// IDトークンとUserInfoのクレームをまとめて返す
+// UserInfoの方がIDトークンの発行後に取得されていて新しいため、同じクレームはUserInfoの値を優先する
+func (ident *Identity) AllClaims() (map[string]any, error) {
+	claims := make(map[string]any)
+	if err := ident.IDTokenClaims.Claims(&claims); err != nil {
+		return nil, err
+	}
+	userInfoClaims := make(map[string]any)
+	if err := ident.UserInfoClaims.Claims(&userInfoClaims); err != nil {
+		return nil, err
+	}
+	for name, value := range userInfoClaims {
+		claims[name] = value
+	}
+	return claims, nil
+}
+
+// *oidc.IDTokenと*oidc.UserInfoはどちらもこのインターフェースを満たす
+type Claims interface {
+	Claims(v any) error
+}
+
+// ログインしているときのみ、*Identityが格納される
+type Key struct{}
@@ -0,0 +1,72 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 認証の強度についての要求
+// ゼロ値は何も要求しない
+type Requirement struct {
+	// いずれかのacrで認証されている必要がある
+	ACRValues []string
+
+	// 全ての認証方式(amr)で認証されている必要がある
+	AMR []string
+
+	// 最後に認証を行ってからの経過時間の上限。0の場合は制限しない
+	MaxAuthAge time.Duration
+}
+
+// Step-upの認証フローの実行中、開始時の要求を後続のハンドラに渡すために使う
+type RequirementKey struct{}
+
+// IdPとの時刻のずれを許容する幅
+const authTimeLeeway time.Duration = 1 * time.Minute
+
+type authenticationClaims struct {
+	ACR      string   `json:"acr"`
+	AMR      []string `json:"amr"`
+	AuthTime float64  `json:"auth_time"`
+}
+
+func (req Requirement) IsEmpty() bool {
+	return len(req.ACRValues) == 0 && len(req.AMR) == 0 && req.MaxAuthAge == 0
+}
+
+// IDトークンのacr、amrおよびauth_timeが要求を満たさない場合は、その理由をエラーとして返す
+func (req Requirement) Check(claims Claims, now time.Time) error {
+	if req.IsEmpty() {
+		return nil
+	}
+	var c authenticationClaims
+	if err := claims.Claims(&c); err != nil {
+		return err
+	}
+
+	errMessages := make([]string, 0)
+	if len(req.ACRValues) > 0 && !slices.Contains(req.ACRValues, c.ACR) {
+		errMessages = append(errMessages, fmt.Sprintf("error: acr is not acceptable: %s", c.ACR))
+	}
+	for _, amr := range req.AMR {
+		if !slices.Contains(c.AMR, amr) {
+			errMessages = append(errMessages, fmt.Sprintf("error: amr does not contain %s", amr))
+		}
+	}
+	if req.MaxAuthAge > 0 {
+		// max_ageを要求した場合、IdPはauth_timeを必ず返す
+		if c.AuthTime == 0 {
+			errMessages = append(errMessages, "error: auth_time is missing")
+		} else if authTime := time.Unix(int64(c.AuthTime), 0); now.Sub(authTime) > req.MaxAuthAge+authTimeLeeway {
+			errMessages = append(errMessages, fmt.Sprintf("error: authentication is too old: %s", authTime.Format(time.RFC3339)))
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
@@ -18,11 +18,17 @@ func Init(c Config) {
 	config = c
 }
 
+// リクエストに紐づかないバックグラウンドの処理で使うロガーを作る
+func NewLogger() *zerolog.Logger {
+	zerolog.TimeFieldFormat = time.RFC3339
+	logger := log.Output(os.Stdout).Level(config.Level)
+	return &logger
+}
+
 func CreateLoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		zerolog.TimeFieldFormat = time.RFC3339
-		logger := log.Output(os.Stdout).Level(config.Level)
-		ctx := context.WithValue(r.Context(), Key{}, &logger)
+		logger := NewLogger()
+		ctx := context.WithValue(r.Context(), Key{}, logger)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -2,9 +2,14 @@ package login
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/bearer"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
@@ -19,21 +24,80 @@ func GetLoginStatusMiddleware(next http.Handler) http.Handler {
 
 		logger.Debug().Msg("Checking login status.")
 
-		_, err := session.GetIDToken(id)
+		// ベアラートークンはリクエストごとに明示的に送られるものなので、Cookieのセッションよりも優先する
+		if token, ok := r.Context().Value(bearer.Key{}).(bearer.Token); ok {
+			logger.Debug().Msg("User is authenticated by bearer token.")
+			*logger = logger.With().Bool("login", true).Bool("bearer", true).Logger()
+			ctx := context.WithValue(r.Context(), Key{}, true)
+			claims := make(map[string]any)
+			if err := token.IDToken.Claims(&claims); err != nil {
+				logger.Error().Err(err).Msg("Failed to read claims of bearer token.")
+				i18n.Error(w, r, "error.loginStatus", http.StatusInternalServerError)
+				return
+			}
+			ctx = context.WithValue(ctx, identity.Key{}, &identity.Identity{
+				ProviderID:     token.ProviderID,
+				IDTokenClaims:  token.IDToken,
+				UserInfoClaims: token.IDToken,
+				Scopes:         identity.GetScopes(claims),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		ident, err := getSessionIdentity(id)
 		var ctx context.Context
 		if err == nil {
 			logger.Debug().Msg("User is logged in.")
 			*logger = logger.With().Bool("login", true).Logger()
 			ctx = context.WithValue(r.Context(), Key{}, true)
-		} else if err.Error() == "error: IDToken not found" {
+			ctx = context.WithValue(ctx, identity.Key{}, ident)
+		} else if errors.Is(err, errNotLoggedIn) {
 			logger.Debug().Msg("User is not logged in.")
 			*logger = logger.With().Bool("login", false).Logger()
 			ctx = context.WithValue(r.Context(), Key{}, false)
 		} else {
 			logger.Error().Err(err).Msg("Unexpected error while fetching login status.")
-			http.Error(w, "error: unexpected error while fetching login status", http.StatusInternalServerError)
+			i18n.Error(w, r, "error.loginStatus", http.StatusInternalServerError)
 			return
 		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+var errNotLoggedIn = errors.New("error: not logged in")
+
+// OIDCのセッションはIDTokenを、OAuth2のセッションはプロフィールを持つ
+// どちらも持たなければ未ログインである
+func getSessionIdentity(id sessionid.ID) (*identity.Identity, error) {
+	var idTokenClaims, userInfoClaims identity.Claims
+	if idToken, err := session.GetIDToken(id); err == nil {
+		userInfo, err := session.GetUserInfo(id)
+		if err != nil {
+			return nil, err
+		}
+		idTokenClaims, userInfoClaims = idToken, userInfo
+	} else if profile, err := session.GetProfile(id); err == nil {
+		idTokenClaims, userInfoClaims = profile, profile
+	} else {
+		return nil, errNotLoggedIn
+	}
+
+	providerID, err := session.GetProviderID(id)
+	if err != nil {
+		return nil, err
+	}
+	// scopeを省略するIdPもあるため、取得できない場合は空として扱う
+	var scopes []string
+	if token, err := session.GetToken(id); err == nil {
+		if scope, ok := token.Extra("scope").(string); ok {
+			scopes = strings.Fields(scope)
+		}
+	}
+	return &identity.Identity{
+		ProviderID:     providerID,
+		IDTokenClaims:  idTokenClaims,
+		UserInfoClaims: userInfoClaims,
+		Scopes:         scopes,
+	}, nil
+}
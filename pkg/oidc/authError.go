@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
+)
+
+// IdPが認可リクエストを拒否し、codeの代わりにerrorを返したときの処理
+// error_descriptionは開発者向けの情報であり、利用者には見せずにログにのみ残す
+func handleAuthorizationError(w http.ResponseWriter, r *http.Request, provider *Provider) {
+	logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+	code := r.FormValue("error")
+
+	logger.Warn().
+		Str("error", code).
+		Str("errorDescription", r.FormValue("error_description")).
+		Str("errorURI", r.FormValue("error_uri")).
+		Msg("Authorization error response from IdP during OIDC callback")
+
+	// このブラウザで開始したフローであれば、同じリダイレクト先でログインをやり直せるようにする
+	state := r.FormValue("state")
+	var retryURL string
+	if flow, err := session.GetFlow(state); err == nil && checkFlow(r, provider, flow) == nil {
+		session.DeleteFlow(state)
+		retryURL = getStartURL(provider, flow.RedirectURL)
+	}
+
+	status, message := getAuthorizationErrorMessage(r, provider, code)
+	renderErrorPageWithRetry(w, r, status, message, retryURL)
+}
+
+func getAuthorizationErrorMessage(r *http.Request, provider *Provider, code string) (int, string) {
+	switch code {
+	case "access_denied":
+		return http.StatusForbidden, i18n.Message(r, "error.accessDenied", provider.DisplayName)
+	case "login_required", "consent_required", "interaction_required", "account_selection_required":
+		return http.StatusUnauthorized, i18n.Message(r, "error.interactionRequired", provider.DisplayName)
+	case "temporarily_unavailable", "server_error":
+		return http.StatusServiceUnavailable, i18n.Message(r, "error.providerUnavailable", provider.DisplayName)
+	default:
+		return http.StatusBadRequest, i18n.Message(r, "error.authorizationFailed", provider.DisplayName, code)
+	}
+}
@@ -0,0 +1,115 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+)
+
+const backChannelLogoutEvent string = "http://schemas.openid.net/event/backchannel-logout"
+
+// IdPとの時刻のずれを許容する幅
+const logoutTokenLeeway time.Duration = 1 * time.Minute
+
+type logoutTokenClaims struct {
+	Subject  string                     `json:"sub"`
+	SID      string                     `json:"sid"`
+	Nonce    string                     `json:"nonce"`
+	JTI      string                     `json:"jti"`
+	IssuedAt float64                    `json:"iat"`
+	Events   map[string]json.RawMessage `json:"events"`
+}
+
+// OpenID Connect Back-Channel Logout 1.0に従い、IdPから直接送られるログアウトトークンを受け付ける
+// このエンドポイントはブラウザを経由しないため、Cookieではなくsub/sidからセッションを特定する
+func createBackChannelLogoutHandler(provider *Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		logger.Debug().Msg("Starting back-channel logout process")
+
+		if r.Method != http.MethodPost {
+			i18n.Error(w, r, "error.methodNotAllowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rawLogoutToken := r.PostFormValue("logout_token")
+		if rawLogoutToken == "" {
+			logger.Error().Msg("Back-channel logout request without logout_token")
+			i18n.Error(w, r, "error.logoutTokenRequired", http.StatusBadRequest)
+			return
+		}
+
+		ids, err := findLogoutTargets(r.Context(), provider, rawLogoutToken)
+		if err != nil {
+			logger.Error().Err(err).Msg("Invalid logout token")
+			i18n.Error(w, r, "error.invalidLogoutToken", http.StatusBadRequest)
+			return
+		}
+
+		for _, id := range ids {
+			logoutExclusively(id)
+		}
+
+		logger.Info().Int("sessions", len(ids)).Msg("Back-channel logout completed successfully")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func findLogoutTargets(ctx context.Context, provider *Provider, rawLogoutToken string) ([]sessionid.ID, error) {
+	// ログアウトトークンの署名、iss、aud、expの検証はIDトークンと同じである
+	logoutToken, err := provider.Verifier.Verify(ctx, rawLogoutToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims logoutTokenClaims
+	if err := logoutToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if err := checkLogoutTokenClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	// 盗まれたログアウトトークンを繰り返し送られないように、一度しか受け付けない
+	if err := session.UseLogoutTokenID(logoutToken.Issuer, claims.JTI, logoutToken.Expiry); err != nil {
+		return nil, err
+	}
+
+	if claims.SID != "" {
+		return session.FindSessionsBySID(logoutToken.Issuer, claims.SID), nil
+	}
+	return session.FindSessionsBySubject(logoutToken.Issuer, claims.Subject), nil
+}
+
+// 署名、iss、audおよびexp以外の、ログアウトトークンに固有の要件を検証する
+func checkLogoutTokenClaims(claims logoutTokenClaims, now time.Time) error {
+	// IDトークンと取り違えられないように、nonceを含むトークンは拒否しなければならない
+	if claims.Nonce != "" {
+		return errors.New("error: logout token must not contain nonce")
+	}
+	if _, found := claims.Events[backChannelLogoutEvent]; !found {
+		return errors.New("error: logout token does not contain back-channel logout event")
+	}
+	if claims.SID == "" && claims.Subject == "" {
+		return errors.New("error: logout token must contain either sub or sid")
+	}
+	if claims.JTI == "" {
+		return errors.New("error: logout token must contain jti")
+	}
+	if claims.IssuedAt == 0 {
+		return errors.New("error: logout token must contain iat")
+	}
+	if issuedAt := time.Unix(int64(claims.IssuedAt), 0); issuedAt.After(now.Add(logoutTokenLeeway)) {
+		return fmt.Errorf("error: logout token is issued in the future: %s", issuedAt.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
+)
+
+func TestCheckLogoutTokenClaims(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	events := map[string]json.RawMessage{backChannelLogoutEvent: json.RawMessage("{}")}
+	valid := logoutTokenClaims{
+		Subject:  "user",
+		SID:      "sid",
+		JTI:      "jti",
+		IssuedAt: float64(now.Unix()),
+		Events:   events,
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *logoutTokenClaims)
+		wantErr bool
+	}{
+		{"valid", func(c *logoutTokenClaims) {}, false},
+		{"sub only", func(c *logoutTokenClaims) { c.SID = "" }, false},
+		{"sid only", func(c *logoutTokenClaims) { c.Subject = "" }, false},
+		{"iat within leeway", func(c *logoutTokenClaims) { c.IssuedAt = float64(now.Add(30 * time.Second).Unix()) }, false},
+		{"nonce", func(c *logoutTokenClaims) { c.Nonce = "nonce" }, true},
+		{"no event", func(c *logoutTokenClaims) { c.Events = nil }, true},
+		{"other event", func(c *logoutTokenClaims) {
+			c.Events = map[string]json.RawMessage{"http://example.com/event": json.RawMessage("{}")}
+		}, true},
+		{"no sub and sid", func(c *logoutTokenClaims) { c.Subject, c.SID = "", "" }, true},
+		{"no jti", func(c *logoutTokenClaims) { c.JTI = "" }, true},
+		{"no iat", func(c *logoutTokenClaims) { c.IssuedAt = 0 }, true},
+		{"iat in future", func(c *logoutTokenClaims) { c.IssuedAt = float64(now.Add(time.Hour).Unix()) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid
+			tt.modify(&claims)
+			err := checkLogoutTokenClaims(claims, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkLogoutTokenClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFindLogoutTargetsRejectsReplay(t *testing.T) {
+	session.Init()
+	const issuer = "https://idp.example.com"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := &Provider{
+		Verifier: oidc.NewVerifier(issuer, &oidc.StaticKeySet{PublicKeys: []crypto.PublicKey{&key.PublicKey}}, &oidc.Config{ClientID: "client"}),
+	}
+
+	now := time.Now()
+	rawToken := signLogoutToken(t, key, map[string]any{
+		"iss":    issuer,
+		"aud":    "client",
+		"sub":    "user",
+		"jti":    "jti-1",
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Minute).Unix(),
+		"events": map[string]any{backChannelLogoutEvent: map[string]any{}},
+	})
+
+	if _, err := findLogoutTargets(context.Background(), provider, rawToken); err != nil {
+		t.Fatalf("first logout token was rejected: %v", err)
+	}
+	if _, err := findLogoutTargets(context.Background(), provider, rawToken); err == nil {
+		t.Fatal("replayed logout token was accepted")
+	}
+}
+
+func signLogoutToken(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawToken, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rawToken
+}
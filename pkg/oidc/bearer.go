@@ -0,0 +1,49 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// ベアラートークンとして送られたJWTを、そのissに対応するプロバイダーの公開鍵で検証する関数を返す
+func NewBearerTokenVerifier(config Config) func(ctx context.Context, rawToken string) (string, *oidc.IDToken, error) {
+	return func(ctx context.Context, rawToken string) (string, *oidc.IDToken, error) {
+		// 全てのプロバイダーで検証を試すと無駄が多いため、署名の検証前にissだけを読んでプロバイダーを決める
+		// 読んだissは、その後の検証で署名と共に確認される
+		issuer, err := getUnverifiedIssuer(rawToken)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, provider := range config.providers {
+			// OAuth2のプロバイダーはJWTを発行しないため、検証に使えない
+			if provider.isReady() && provider.BearerVerifier != nil && provider.Issuer == issuer {
+				idToken, err := provider.BearerVerifier.Verify(ctx, rawToken)
+				return provider.ID, idToken, err
+			}
+		}
+		return "", nil, errors.New("error: token was not issued by any configured provider")
+	}
+}
+
+func getUnverifiedIssuer(rawToken string) (string, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("error: token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", err
+	}
+	return claims.Issuer, nil
+}
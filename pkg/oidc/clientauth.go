@@ -0,0 +1,179 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/crypto"
+	"golang.org/x/oauth2"
+)
+
+const (
+	clientAuthMethodClientSecretJWT string = "client_secret_jwt"
+	clientAuthMethodPrivateKeyJWT   string = "private_key_jwt"
+)
+
+const clientAssertionType string = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// client_assertionは使い捨てのため、有効期限は短くてよい
+const clientAssertionLifetime time.Duration = 1 * time.Minute
+
+// JWTによるクライアント認証を行う場合は署名に使うSignerを、それ以外の場合はnilを返す
+func createClientAssertionSigner(p ProviderSchema) (jose.Signer, error) {
+	var key jose.SigningKey
+	switch p.ClientAuthMethod {
+	case clientAuthMethodClientSecretJWT:
+		key = jose.SigningKey{Algorithm: jose.HS256, Key: []byte(p.ClientSecret)}
+	case clientAuthMethodPrivateKeyJWT:
+		privateKey, err := loadPrivateKey(p.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		algorithm, err := getSignatureAlgorithm(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		key = jose.SigningKey{Algorithm: algorithm, Key: privateKey}
+	default:
+		return nil, nil
+	}
+
+	options := (&jose.SignerOptions{}).WithType("JWT")
+	if p.PrivateKeyID != "" {
+		options = options.WithHeader("kid", p.PrivateKeyID)
+	}
+	return jose.NewSigner(key, options)
+}
+
+// PKCS#8、PKCS#1、SEC 1のいずれかの形式のPEMファイルから秘密鍵を読み込む
+func loadPrivateKey(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("error: no PEM data found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("error: unsupported private key format in %s", path)
+}
+
+func getSignatureAlgorithm(key any) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+	}
+	return "", errors.New("error: private key must be RSA or EC (P-256, P-384, P-521)")
+}
+
+// トークンエンドポイントとPARエンドポイントへのリクエストに、その都度署名したclient_assertionを付与する
+// 認可コードの交換とリフレッシュのどちらもoauth2パッケージが行うため、HTTPクライアントの層で差し込む
+type clientAssertionTransport struct {
+	signer    jose.Signer
+	clientID  string
+	endpoints []string
+	base      http.RoundTripper
+}
+
+func (t *clientAssertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !slices.Contains(t.endpoints, req.URL.String()) {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	assertion, err := t.createClientAssertion(req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	values.Set("client_assertion_type", clientAssertionType)
+	values.Set("client_assertion", assertion)
+
+	encoded := values.Encode()
+	newReq := req.Clone(req.Context())
+	newReq.Body = io.NopCloser(strings.NewReader(encoded))
+	newReq.ContentLength = int64(len(encoded))
+	newReq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(encoded)), nil
+	}
+	return t.base.RoundTrip(newReq)
+}
+
+// 認可サーバーはトークンエンドポイントとPARエンドポイントのどちらのURLもaudとして受け入れる(RFC 9126)
+func (t *clientAssertionTransport) createClientAssertion(audience string) (string, error) {
+	jti, err := crypto.RandString(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:   t.clientID,
+		Subject:  t.clientID,
+		Audience: jwt.Audience{audience},
+		ID:       jti,
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	}
+	return jwt.Signed(t.signer).Claims(claims).CompactSerialize()
+}
+
+// クライアント認証が必要なエンドポイントと通信する処理には、このContextを渡す
+func (p *Provider) clientAuthContext(ctx context.Context) context.Context {
+	if p.clientAssertionSigner == nil {
+		return ctx
+	}
+	endpoints := []string{p.OAuth2Config.Endpoint.TokenURL}
+	if p.PushedAuthorizationRequestEndpoint != "" {
+		endpoints = append(endpoints, p.PushedAuthorizationRequestEndpoint)
+	}
+	client := &http.Client{
+		Transport: &clientAssertionTransport{
+			signer:    p.clientAssertionSigner,
+			clientID:  p.OAuth2Config.ClientID,
+			endpoints: endpoints,
+			base:      http.DefaultTransport,
+		},
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, client)
+}
@@ -1,19 +1,87 @@
 package oidc
 
 import (
+	"sync/atomic"
+
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v3"
 	"golang.org/x/oauth2"
 )
 
 type Config struct {
-	providers     []Provider
-	skipLoginPage bool
+	providers              []*Provider
+	skipLoginPage          bool
+	postLogoutRedirectURLs []string
+
+	// メールアドレスのドメインからプロバイダーのIDへの対応。空の場合はHome Realm Discoveryを行わない
+	emailDomains map[string]string
+
+	// trueの場合、未ログインのfetchやXMLHttpRequestにはIdPへのリダイレクトではなく401のJSONを返す
+	unauthorizedJSON bool
 }
 
+type ProviderType int
+
+const (
+	ProviderTypeOIDC ProviderType = iota
+	// IDトークンを発行せず、プロフィールのエンドポイントでユーザーを識別するプロバイダー
+	ProviderTypeOAuth2
+)
+
+// Discoveryはバックグラウンドで行われるため、ハンドラ間で共有できるようにポインタで扱う
 type Provider struct {
-	ID           string
-	StartPath    string
+	ID          string
+	DisplayName string
+	IconURL     string
+	Type        ProviderType
+	Optional    bool
+	StartPath   string
+	RedirectURL string
+	PKCE        bool
+
+	// 空文字列の場合はresponse_modeを送らない
+	ResponseMode string
+
+	// OAuth2のプロバイダーでのみ使う
+	ProfileEndpoint string
+	ClaimMapping    map[string]string
+
+	// Back-Channel Logoutを受け付けない場合は空文字列
+	BackChannelLogoutPath string
+
+	// Front-Channel Logoutを受け付けない場合は空文字列
+	// リクエストにはissとsidが必須である
+	FrontChannelLogoutPath string
+
+	// JWTによるクライアント認証を行わない場合はnil
+	clientAssertionSigner jose.Signer
+
+	// 以下はDiscoveryによって設定されるため、isReadyがtrueになるまで読んではいけない
+	// readyの書き込みが全ての設定の後に行われるため、isReadyを確認した後は排他なしで読んでよい
+	ready atomic.Bool
+
+	Issuer       string
+	OAuth2Config *oauth2.Config
+
+	// OAuth2のプロバイダーではnilである
 	OIDCProvider *oidc.Provider
 	Verifier     *oidc.IDTokenVerifier
-	OAuth2Config *oauth2.Config
+
+	// ベアラートークンのaudはクライアントIDとは限らないため、audを検証しないVerifierを別に用意する
+	BearerVerifier *oidc.IDTokenVerifier
+
+	// RP-Initiated Logoutに対応していないIdPの場合は空文字列
+	EndSessionEndpoint string
+
+	// PARを使わない場合は空文字列
+	PushedAuthorizationRequestEndpoint string
+
+	// trueの場合、IdPは認可レスポンスに必ずissを付与する(RFC 9207)
+	ISSParameterSupported bool
+
+	schema ProviderSchema
+}
+
+func (p *Provider) isReady() bool {
+	return p.ready.Load()
 }
@@ -1,36 +1,120 @@
 package oidc
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net/url"
 	"regexp"
 	"strings"
-
-	"github.com/coreos/go-oidc/v3/oidc"
-	"golang.org/x/oauth2"
 )
 
 type ConfigSchema struct {
 	Providers     []ProviderSchema `json:"providers"`
 	SkipLoginPage bool             `json:"skipLoginPage"`
+
+	// ログアウト後のリダイレクト先として許可するURL
+	// オープンリダイレクトを防ぐため、ここに完全一致するURL以外へはリダイレクトしない
+	PostLogoutRedirectURLs []string `json:"postLogoutRedirectURLs"`
+
+	// メールアドレスのドメインから、そのユーザーがログインするプロバイダーのIDへの対応
+	// 指定すると、ログインページにメールアドレスの入力欄を表示する
+	EmailDomains map[string]string `json:"emailDomains"`
+
+	// trueの場合、未ログインのfetchやXMLHttpRequestによるリクエストに、302ではなく401のJSONを返す
+	// JSONには/oauth2/providersのURLが含まれるため、SPAは独自のログイン画面を表示できる
+	UnauthorizedJSON bool `json:"unauthorizedJSON"`
 }
 
 type ProviderSchema struct {
-	ID           string `json:"id"`
+	// "oidc"または"oauth2"。省略した場合は"oidc"
+	Type string `json:"type"`
+
+	ID string `json:"id"`
+
+	// ログインページに表示する名前とアイコンのURL。名前を省略した場合はIDを表示する
+	DisplayName string `json:"displayName"`
+	Icon        string `json:"icon"`
+
 	ClientID     string `json:"clientID"`
 	ClientSecret string `json:"clientSecret"`
 	RedirectURL  string `json:"redirectURL"`
 	StartPath    string `json:"startPath"`
 
+	// trueの場合、PKCE(S256)を使用する。ClientSecretを持たないパブリッククライアントでは必須
+	PKCE bool `json:"pkce"`
+
+	// 認可レスポンスの返し方。"query"または"form_post"。省略した場合はIdPのデフォルト(通常はquery)
+	ResponseMode string `json:"responseMode"`
+
+	// trueの場合、IdPがPARエンドポイントを公開していれば、Pushed Authorization Requests(RFC 9126)を使う
+	PAR bool `json:"par"`
+
+	// トークンエンドポイントでのクライアント認証方式。"client_secret_jwt"または"private_key_jwt"
+	// 省略した場合はclientSecretをそのまま送る
+	ClientAuthMethod string `json:"clientAuthMethod"`
+
+	// private_key_jwtで署名に使う、RSAまたはECの秘密鍵のPEMファイルのパスと、IdPに登録した鍵のkid
+	PrivateKeyFile string `json:"privateKeyFile"`
+	PrivateKeyID   string `json:"privateKeyID"`
+
 	// スコープの内、"oidc"を除いたもの。oidcは自動追加するため不要
 	Scopes []string `json:"scopes"`
 
+	// trueの場合、offline_accessスコープを要求してリフレッシュトークンを受け取り、セッションを自動で延長する
+	OfflineAccess bool `json:"offlineAccess"`
+
+	// IdPからBack-Channel Logoutのログアウトトークンを受け取るパス。省略した場合は受け付けない
+	BackChannelLogoutPath string `json:"backChannelLogoutPath"`
+
+	// IdPがFront-Channel Logoutのiframeで読み込むパス。省略した場合は受け付けない
+	FrontChannelLogoutPath string `json:"frontChannelLogoutPath"`
+
+	// IdPに登録したfrontchannel_logout_session_requiredと同じ値を指定する
+	// issとsidが無いリクエストでは、どのセッションを終了させるかをCookieでしか判断できず、
+	// 任意のサイトに埋め込まれたiframeからログアウトさせられてしまうため、frontChannelLogoutPathを使う場合はtrueでなければならない
+	FrontChannelLogoutSessionRequired bool `json:"frontChannelLogoutSessionRequired"`
+
 	// IssuerからOIDC Discoveryを使うため、その他の情報は不要
 	Issuer string `json:"issuer"`
+
+	// trueの場合、このプロバイダーのDiscoveryが完了していなくてもreadyとみなす
+	Optional bool `json:"optional"`
+
+	// OAuth2のプロバイダーと、OIDC Discoveryを使わないOIDCのプロバイダーで指定する
+	AuthorizationEndpoint string `json:"authorizationEndpoint"`
+	TokenEndpoint         string `json:"tokenEndpoint"`
+
+	// jwksURIを指定すると、OIDC Discoveryを行わずに以下の値とIssuerなどからプロバイダーを構成する
+	// Discoveryを公開していないIdPや、起動時にIdPへ到達できない環境のために使う
+	JWKSURI                 string   `json:"jwksURI"`
+	UserInfoEndpoint        string   `json:"userInfoEndpoint"`
+	EndSessionEndpoint      string   `json:"endSessionEndpoint"`
+	IDTokenSigningAlgValues []string `json:"idTokenSigningAlgValues"`
+
+	PushedAuthorizationRequestEndpoint string `json:"pushedAuthorizationRequestEndpoint"`
+
+	AuthorizationResponseISSParameterSupported bool `json:"authorizationResponseISSParameterSupported"`
+
+	// 以下はOAuth2のプロバイダーでのみ使う
+
+	// アクセストークンを使ってユーザーのプロフィールをJSONで取得するエンドポイント
+	ProfileEndpoint string `json:"profileEndpoint"`
+
+	// 標準クレーム名から、プロフィールのJSONのフィールドへのパスへの対応
+	// 指定されていないクレームは、同じ名前のフィールドから取得する
+	ClaimMapping map[string]string `json:"claimMapping"`
 }
 
+const (
+	providerTypeOIDC   string = "oidc"
+	providerTypeOAuth2 string = "oauth2"
+)
+
+const (
+	responseModeQuery    string = "query"
+	responseModeFormPost string = "form_post"
+)
+
 func (s *ConfigSchema) Validate() error {
 	errMessages := make([]string, 0)
 
@@ -46,14 +130,45 @@ func (s *ConfigSchema) Validate() error {
 		providerIDs[p.ID] = true
 	}
 
+	for _, p := range s.Providers {
+		if p.Type != "" && p.Type != providerTypeOIDC && p.Type != providerTypeOAuth2 {
+			errMessages = append(errMessages, fmt.Sprintf("error: provider type is invalid: %s", p.Type))
+		}
+	}
+
 	if err := validateURLs(s.Providers); err != nil {
 		errMessages = append(errMessages, err.Error())
 	}
 
-	if err := validateStartPath(s.Providers); err != nil {
+	for _, p := range s.Providers {
+		if p.ResponseMode != "" && p.ResponseMode != responseModeQuery && p.ResponseMode != responseModeFormPost {
+			errMessages = append(errMessages, fmt.Sprintf("error: provider responseMode is invalid: %s", p.ResponseMode))
+		}
+	}
+
+	if err := validatePaths(s.Providers); err != nil {
+		errMessages = append(errMessages, err.Error())
+	}
+
+	if err := validateClientAuthentication(s.Providers); err != nil {
 		errMessages = append(errMessages, err.Error())
 	}
 
+	for _, u := range s.PostLogoutRedirectURLs {
+		if !isValidURL(u) {
+			errMessages = append(errMessages, fmt.Sprintf("error: postLogoutRedirectURL is not a valid URL: %s", u))
+		}
+	}
+
+	for domain, providerID := range s.EmailDomains {
+		if domain == "" || strings.Contains(domain, "@") {
+			errMessages = append(errMessages, fmt.Sprintf("error: emailDomains has invalid domain: %s", domain))
+		}
+		if _, exists := providerIDs[providerID]; !exists {
+			errMessages = append(errMessages, fmt.Sprintf("error: emailDomains refers to unknown provider: %s", providerID))
+		}
+	}
+
 	if s.SkipLoginPage && len(s.Providers) > 1 {
 		errMessages = append(errMessages, "error: cannot skip login page because there are more than one provider")
 	}
@@ -68,9 +183,39 @@ func validateURLs(providers []ProviderSchema) error {
 	errMessages := make([]string, 0)
 
 	for _, p := range providers {
-		if !strings.HasPrefix(p.Issuer, "https://") || !isValidURL(p.Issuer) {
+		if p.Type == providerTypeOAuth2 {
+			if !isValidHTTPSURL(p.AuthorizationEndpoint) {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider authorizationEndpoint is not a valid https URL: %s", p.AuthorizationEndpoint))
+			}
+			if !isValidHTTPSURL(p.TokenEndpoint) {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider tokenEndpoint is not a valid https URL: %s", p.TokenEndpoint))
+			}
+			if !isValidHTTPSURL(p.ProfileEndpoint) {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider profileEndpoint is not a valid https URL: %s", p.ProfileEndpoint))
+			}
+		} else if !strings.HasPrefix(p.Issuer, "https://") || !isValidURL(p.Issuer) {
 			errMessages = append(errMessages, fmt.Sprintf("error: provider issuer is not a valid https URL: %s", p.Issuer))
 		}
+		if p.Type != providerTypeOAuth2 && hasStaticMetadata(p) {
+			if !isValidHTTPSURL(p.AuthorizationEndpoint) {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider authorizationEndpoint is not a valid https URL: %s", p.AuthorizationEndpoint))
+			}
+			if !isValidHTTPSURL(p.TokenEndpoint) {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider tokenEndpoint is not a valid https URL: %s", p.TokenEndpoint))
+			}
+			if !isValidHTTPSURL(p.JWKSURI) {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider jwksURI is not a valid https URL: %s", p.JWKSURI))
+			}
+			if !isValidHTTPSURL(p.UserInfoEndpoint) {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider userInfoEndpoint is not a valid https URL: %s", p.UserInfoEndpoint))
+			}
+			if p.EndSessionEndpoint != "" && !isValidHTTPSURL(p.EndSessionEndpoint) {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider endSessionEndpoint is not a valid https URL: %s", p.EndSessionEndpoint))
+			}
+			if p.PushedAuthorizationRequestEndpoint != "" && !isValidHTTPSURL(p.PushedAuthorizationRequestEndpoint) {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider pushedAuthorizationRequestEndpoint is not a valid https URL: %s", p.PushedAuthorizationRequestEndpoint))
+			}
+		}
 		if !strings.HasPrefix(p.RedirectURL, "https://") || !isValidURL(p.RedirectURL) {
 			errMessages = append(errMessages, fmt.Sprintf("error: provider redirectURL is not a valid https URL: %s", p.RedirectURL))
 		}
@@ -82,13 +227,61 @@ func validateURLs(providers []ProviderSchema) error {
 	return nil
 }
 
-func validateStartPath(providers []ProviderSchema) error {
+func validatePaths(providers []ProviderSchema) error {
 	errMessages := make([]string, 0)
 
 	for _, p := range providers {
 		if !isValidPath(p.StartPath) {
 			errMessages = append(errMessages, fmt.Sprintf("error: provider startPath is not a valid path: %s", p.StartPath))
 		}
+		if p.BackChannelLogoutPath != "" && !isValidPath(p.BackChannelLogoutPath) {
+			errMessages = append(errMessages, fmt.Sprintf("error: provider backChannelLogoutPath is not a valid path: %s", p.BackChannelLogoutPath))
+		}
+		if p.FrontChannelLogoutPath != "" && !isValidPath(p.FrontChannelLogoutPath) {
+			errMessages = append(errMessages, fmt.Sprintf("error: provider frontChannelLogoutPath is not a valid path: %s", p.FrontChannelLogoutPath))
+		}
+		// OAuth2のプロバイダーはIDトークンもIssuerも持たないため、ログアウトトークンの検証もissとsidの検証もできない
+		if p.Type == providerTypeOAuth2 && p.BackChannelLogoutPath != "" {
+			errMessages = append(errMessages, fmt.Sprintf("error: oauth2 provider cannot have backChannelLogoutPath: %s", p.ID))
+		}
+		if p.Type == providerTypeOAuth2 && p.FrontChannelLogoutPath != "" {
+			errMessages = append(errMessages, fmt.Sprintf("error: oauth2 provider cannot have frontChannelLogoutPath: %s", p.ID))
+		}
+		if p.FrontChannelLogoutPath != "" && !p.FrontChannelLogoutSessionRequired {
+			errMessages = append(errMessages, fmt.Sprintf("error: provider with frontChannelLogoutPath requires frontChannelLogoutSessionRequired: %s", p.ID))
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
+func validateClientAuthentication(providers []ProviderSchema) error {
+	errMessages := make([]string, 0)
+
+	for _, p := range providers {
+		switch p.ClientAuthMethod {
+		case "":
+		case clientAuthMethodClientSecretJWT:
+			if p.ClientSecret == "" {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider with client_secret_jwt requires clientSecret: %s", p.ID))
+			}
+		case clientAuthMethodPrivateKeyJWT:
+			if p.PrivateKeyFile == "" {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider with private_key_jwt requires privateKeyFile: %s", p.ID))
+			} else if _, err := createClientAssertionSigner(p); err != nil {
+				errMessages = append(errMessages, fmt.Sprintf("error: provider privateKeyFile cannot be used: %s: %s", p.ID, err.Error()))
+			}
+		default:
+			errMessages = append(errMessages, fmt.Sprintf("error: provider clientAuthMethod is invalid: %s", p.ClientAuthMethod))
+		}
+
+		// ClientSecretを持たないパブリッククライアントは、認可コードの横取りを防ぐ手段がPKCEしかない
+		if p.ClientSecret == "" && p.ClientAuthMethod != clientAuthMethodPrivateKeyJWT && !p.PKCE {
+			errMessages = append(errMessages, fmt.Sprintf("error: provider without clientSecret must enable pkce: %s", p.ID))
+		}
 	}
 
 	if len(errMessages) > 0 {
@@ -102,45 +295,66 @@ func isValidURL(toTest string) bool {
 	return err == nil && u.Scheme != "" && u.Host != ""
 }
 
+func isValidHTTPSURL(toTest string) bool {
+	return strings.HasPrefix(toTest, "https://") && isValidURL(toTest)
+}
+
 func isValidPath(path string) bool {
 	regex := regexp.MustCompile(`^/([A-Za-z0-9\-_]+(/|$))*$`)
 	return regex.MatchString(path)
 }
 
 func (s *ConfigSchema) CreateConfig() Config {
-	providers := make([]Provider, 0)
+	providers := make([]*Provider, 0)
 	for _, p := range s.Providers {
-		ctx := context.Background()
-		provider, err := oidc.NewProvider(ctx, p.Issuer)
+		providerType := ProviderTypeOIDC
+		if p.Type == providerTypeOAuth2 {
+			providerType = ProviderTypeOAuth2
+		}
+		displayName := p.DisplayName
+		if displayName == "" {
+			displayName = p.ID
+		}
+		// Validateで読み込めることを確認済み
+		signer, err := createClientAssertionSigner(p)
 		if err != nil {
 			panic(err)
 		}
+		// IdPとの通信が必要な情報は、起動後にStartDiscoveryで取得する
+		providers = append(providers, &Provider{
+			ID:          p.ID,
+			DisplayName: displayName,
+			IconURL:     p.Icon,
+			Type:        providerType,
+			Optional:    p.Optional,
+			StartPath:   p.StartPath,
+			RedirectURL: p.RedirectURL,
+			PKCE:        p.PKCE,
 
-		oidcConfig := &oidc.Config{
-			ClientID: p.ClientID,
-		}
-		verifier := provider.Verifier(oidcConfig)
+			ResponseMode: p.ResponseMode,
 
-		scopes := p.Scopes
-		scopes = append(scopes, oidc.ScopeOpenID)
-		config := &oauth2.Config{
-			ClientID:     p.ClientID,
-			ClientSecret: p.ClientSecret,
-			Endpoint:     provider.Endpoint(),
-			RedirectURL:  p.RedirectURL,
-			Scopes:       scopes,
-		}
+			ProfileEndpoint: p.ProfileEndpoint,
+			ClaimMapping:    p.ClaimMapping,
 
-		providers = append(providers, Provider{
-			ID:           p.ID,
-			StartPath:    p.StartPath,
-			OIDCProvider: provider,
-			Verifier:     verifier,
-			OAuth2Config: config,
+			BackChannelLogoutPath: p.BackChannelLogoutPath,
+
+			FrontChannelLogoutPath: p.FrontChannelLogoutPath,
+
+			clientAssertionSigner: signer,
+
+			schema: p,
 		})
 	}
+	// ドメインは大文字と小文字を区別しないため、小文字に揃えておく
+	emailDomains := make(map[string]string)
+	for domain, providerID := range s.EmailDomains {
+		emailDomains[strings.ToLower(domain)] = providerID
+	}
 	return Config{
-		providers:     providers,
-		skipLoginPage: s.SkipLoginPage,
+		providers:              providers,
+		skipLoginPage:          s.SkipLoginPage,
+		postLogoutRedirectURLs: s.PostLogoutRedirectURLs,
+		emailDomains:           emailDomains,
+		unauthorizedJSON:       s.UnauthorizedJSON,
 	}
 }
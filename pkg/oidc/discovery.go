@@ -0,0 +1,179 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"golang.org/x/oauth2"
+)
+
+// IdPが一時的に到達できないだけで起動に失敗しないよう、Discoveryは指数バックオフで成功するまで繰り返す
+const initialDiscoveryInterval time.Duration = 1 * time.Second
+const maxDiscoveryInterval time.Duration = 1 * time.Minute
+
+// 全てのプロバイダーのDiscoveryをバックグラウンドで開始する
+func StartDiscovery(config Config) {
+	for _, provider := range config.providers {
+		go discoverUntilReady(provider)
+	}
+}
+
+func discoverUntilReady(provider *Provider) {
+	logger := log.NewLogger().With().Str("providerID", provider.ID).Logger()
+	interval := initialDiscoveryInterval
+	for {
+		err := provider.discover(context.Background())
+		if err == nil {
+			logger.Info().Msg("Provider is ready")
+			return
+		}
+		logger.Warn().Err(err).Dur("retryAfter", interval).Msg("Failed to discover provider")
+		time.Sleep(interval)
+		interval = min(interval*2, maxDiscoveryInterval)
+	}
+}
+
+func (p *Provider) discover(ctx context.Context) error {
+	s := p.schema
+	if p.Type == ProviderTypeOAuth2 {
+		// OAuth2にはリフレッシュトークンを要求する標準のスコープが無いため、offlineAccessは使わない
+		endpoint := oauth2.Endpoint{
+			AuthURL:  s.AuthorizationEndpoint,
+			TokenURL: s.TokenEndpoint,
+		}
+		p.OAuth2Config = createOAuth2Config(s, endpoint, s.Scopes)
+		p.ready.Store(true)
+		return nil
+	}
+
+	provider, metadata, err := newOIDCProvider(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	scopes := s.Scopes
+	scopes = append(scopes, oidc.ScopeOpenID)
+	if s.OfflineAccess {
+		scopes = append(scopes, oidc.ScopeOfflineAccess)
+	}
+
+	p.Issuer = metadata.Issuer
+	p.OAuth2Config = createOAuth2Config(s, provider.Endpoint(), scopes)
+	p.OIDCProvider = provider
+	p.Verifier = provider.Verifier(&oidc.Config{
+		ClientID:             s.ClientID,
+		SupportedSigningAlgs: s.IDTokenSigningAlgValues,
+	})
+	p.BearerVerifier = provider.Verifier(&oidc.Config{
+		SkipClientIDCheck:    true,
+		SupportedSigningAlgs: s.IDTokenSigningAlgValues,
+	})
+	p.EndSessionEndpoint = metadata.EndSessionEndpoint
+	if s.PAR {
+		p.PushedAuthorizationRequestEndpoint = metadata.PushedAuthorizationRequestEndpoint
+	}
+	p.ISSParameterSupported = metadata.AuthorizationResponseISSParameterSupported
+	p.ready.Store(true)
+	return nil
+}
+
+// go-oidcのProviderが保持していない、Discoveryで得られる追加のメタデータ
+type providerMetadata struct {
+	Issuer                             string `json:"issuer"`
+	EndSessionEndpoint                 string `json:"end_session_endpoint"`
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint"`
+
+	AuthorizationResponseISSParameterSupported bool `json:"authorization_response_iss_parameter_supported"`
+}
+
+func hasStaticMetadata(p ProviderSchema) bool {
+	return p.JWKSURI != ""
+}
+
+// 設定ファイルにメタデータが書かれていればそれを使い、無ければOIDC Discoveryで取得する
+func newOIDCProvider(ctx context.Context, p ProviderSchema) (*oidc.Provider, providerMetadata, error) {
+	if hasStaticMetadata(p) {
+		providerConfig := &oidc.ProviderConfig{
+			IssuerURL:   p.Issuer,
+			AuthURL:     p.AuthorizationEndpoint,
+			TokenURL:    p.TokenEndpoint,
+			UserInfoURL: p.UserInfoEndpoint,
+			JWKSURL:     p.JWKSURI,
+			Algorithms:  p.IDTokenSigningAlgValues,
+		}
+		metadata := providerMetadata{
+			Issuer:                             p.Issuer,
+			EndSessionEndpoint:                 p.EndSessionEndpoint,
+			PushedAuthorizationRequestEndpoint: p.PushedAuthorizationRequestEndpoint,
+
+			AuthorizationResponseISSParameterSupported: p.AuthorizationResponseISSParameterSupported,
+		}
+		return providerConfig.NewProvider(ctx), metadata, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.Issuer)
+	if err != nil {
+		return nil, providerMetadata{}, err
+	}
+	var metadata providerMetadata
+	if err := provider.Claims(&metadata); err != nil {
+		return nil, providerMetadata{}, err
+	}
+	return provider, metadata, nil
+}
+
+func createOAuth2Config(p ProviderSchema, endpoint oauth2.Endpoint, scopes []string) *oauth2.Config {
+	clientSecret := p.ClientSecret
+	if p.ClientAuthMethod != "" {
+		// JWTによるクライアント認証では、clientSecretを送らずにclient_assertionを付与する
+		clientSecret = ""
+	}
+	if clientSecret == "" {
+		// パブリッククライアントはBasic認証を行えないため、client_idはリクエストボディで送る
+		endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: clientSecret,
+		Endpoint:     endpoint,
+		RedirectURL:  p.RedirectURL,
+		Scopes:       scopes,
+	}
+}
+
+// 必須のプロバイダーが全てDiscoveryを終えていればnilを返す
+func NewReadinessCheck(config Config) func() error {
+	return func() error {
+		notReady := make([]string, 0)
+		for _, provider := range config.providers {
+			if !provider.Optional && !provider.isReady() {
+				notReady = append(notReady, provider.ID)
+			}
+		}
+		if len(notReady) > 0 {
+			return fmt.Errorf("error: providers are not discovered yet: %s", strings.Join(notReady, ", "))
+		}
+		return nil
+	}
+}
+
+// Discoveryが終わっていないプロバイダーのエンドポイントには、503のエラーページを返す
+func requireReady(provider *Provider, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !provider.isReady() {
+			logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+			logger.Warn().Str("providerID", provider.ID).Msg("Request to provider which is not ready")
+			w.Header().Set("Retry-After", "10")
+			renderErrorPage(w, r, http.StatusServiceUnavailable, i18n.Message(r, "error.providerUnavailable", provider.DisplayName))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
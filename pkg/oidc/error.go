@@ -0,0 +1,20 @@
+package oidc
+
+import (
+	"net/http"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/page"
+)
+
+// ブラウザで表示されるエンドポイントのエラーを、素のテキストではなくHTMLのページで返す
+func renderErrorPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	renderErrorPageWithRetry(w, r, status, message, "")
+}
+
+// ログインをやり直すことで解決しうるエラーでは、やり直すためのリンクを表示する
+func renderErrorPageWithRetry(w http.ResponseWriter, r *http.Request, status int, message string, retryURL string) {
+	page.RenderError(w, r, status, page.ErrorData{
+		Message:  message,
+		RetryURL: retryURL,
+	})
+}
@@ -0,0 +1,53 @@
+package oidc
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/crypto"
+)
+
+// 認証フローを開始したブラウザを、コールバックで確認するためのCookie
+// form_postのコールバックはIdPからのクロスサイトのPOSTであり、SameSite=LaxのセッションのCookieは送られない
+// そのため、攻撃者が自分のブラウザで得たstateとcodeを被害者のブラウザからPOSTさせ、
+// 攻撃者のアカウントでログインさせること(Login CSRF)を防ぐには、クロスサイトでも送られる別のCookieが必要である
+const flowBindingCookieName string = "flow_binding"
+
+// フロー自体の有効期限よりも長ければよい
+const flowBindingExpireTime time.Duration = 10 * time.Minute
+
+// 既にCookieがあれば同じ値を使う。複数のタブで並行して開始したフローが、互いのCookieを上書きしないようにするため
+func setFlowBindingCookie(w http.ResponseWriter, r *http.Request) (string, error) {
+	binding := ""
+	if cookie, err := r.Cookie(flowBindingCookieName); err == nil && cookie.Value != "" {
+		binding = cookie.Value
+	} else {
+		binding, err = crypto.RandString(16)
+		if err != nil {
+			return "", err
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flowBindingCookieName,
+		Value:    binding,
+		Path:     Path,
+		MaxAge:   int(flowBindingExpireTime.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+	return binding, nil
+}
+
+func checkFlowBinding(r *http.Request, binding string) error {
+	cookie, err := r.Cookie(flowBindingCookieName)
+	if err != nil {
+		return errors.New("error: flow binding cookie is missing")
+	}
+	if binding == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(binding)) != 1 {
+		return errors.New("error: flow was started in another browser")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+)
+
+func TestCheckFlow(t *testing.T) {
+	flow := session.Flow{
+		State:      "state",
+		SessionID:  "victim-session",
+		ProviderID: "idp",
+		Binding:    "binding",
+	}
+
+	tests := []struct {
+		name         string
+		responseMode string
+		providerID   string
+		sessionID    sessionid.ID
+		cookie       string
+		wantErr      bool
+	}{
+		{"query", responseModeQuery, "idp", "victim-session", "binding", false},
+		{"form_post without session cookie", responseModeFormPost, "idp", "new-session", "binding", false},
+		{"form_post without binding cookie", responseModeFormPost, "idp", "new-session", "", true},
+		{"form_post from another browser", responseModeFormPost, "idp", "new-session", "attacker-binding", true},
+		{"query from another session", responseModeQuery, "idp", "attacker-session", "binding", true},
+		{"another provider", responseModeQuery, "other", "victim-session", "binding", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/oauth2/callback", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: flowBindingCookieName, Value: tt.cookie})
+			}
+			r = r.WithContext(context.WithValue(r.Context(), sessionid.Key{}, tt.sessionID))
+			provider := &Provider{ID: tt.providerID, ResponseMode: tt.responseMode}
+
+			err := checkFlow(r, provider, flow)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkFlow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package oidc
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/bearer"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/page"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
+)
+
+// ログインしているが、Upstreamへのアクセスが許可されていないユーザーに403を返す
+// 別のアカウントでログインし直せるように、ログイン中のユーザーとログアウトのリンクを表示する
+func NewForbiddenHandler(config Config) http.Handler {
+	return noCacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+
+		// ベアラートークンはログアウトで取り直すものではないため、ページは返さない
+		if _, ok := r.Context().Value(bearer.Key{}).(bearer.Token); ok {
+			i18n.Error(w, r, "error.forbidden", http.StatusForbidden)
+			return
+		}
+
+		ident := r.Context().Value(identity.Key{}).(*identity.Identity)
+		signedInAs, err := getSignedInAs(config, ident)
+		if err != nil {
+			logger.Warn().Err(err).Msg("Failed to get the name of the current user")
+		}
+
+		page.RenderError(w, r, http.StatusForbidden, page.ErrorData{
+			Message:    i18n.Message(r, "error.forbidden"),
+			SignedInAs: signedInAs,
+			SignOutURL: proxyURL.GetURLFromPath(Path + signOutPath).String(),
+		})
+	}))
+}
+
+// ユーザーが見分けられるように、メールアドレスなどの表示に適したクレームと、ログインしたプロバイダーの名前を返す
+func getSignedInAs(config Config, ident *identity.Identity) (string, error) {
+	claims, err := ident.AllClaims()
+	if err != nil {
+		return "", err
+	}
+	name := ""
+	for _, claim := range []string{"email", "preferred_username", "name", "sub"} {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			name = value
+			break
+		}
+	}
+	if provider, found := findProvider(config, ident.ProviderID); found {
+		return fmt.Sprintf("%s (%s)", name, provider.DisplayName), nil
+	}
+	return name, nil
+}
@@ -0,0 +1,49 @@
+package oidc
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
+)
+
+// OpenID Connect Front-Channel Logout 1.0に従い、IdPがiframeで読み込むログアウト用のURLを処理する
+// 任意のサイトがこのURLをiframeで読み込めるため、Cookieのセッションではなく、issとsidで指定されたセッションのみをログアウトさせる
+func createFrontChannelLogoutHandler(provider *Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		logger.Debug().Msg("Starting front-channel logout process")
+
+		iss := r.URL.Query().Get("iss")
+		sid := r.URL.Query().Get("sid")
+
+		if iss == "" || sid == "" {
+			logger.Error().Msg("Front-channel logout request without iss and sid")
+			i18n.Error(w, r, "error.frontChannelParamsRequired", http.StatusBadRequest)
+			return
+		}
+
+		// issはsidが有効な範囲を表すため、必ず検証する
+		if iss != provider.Issuer {
+			logger.Error().Str("iss", iss).Msg("Front-channel logout request with unexpected issuer")
+			i18n.Error(w, r, "error.issuerMismatch", http.StatusBadRequest)
+			return
+		}
+
+		ids := session.FindSessionsBySID(iss, sid)
+		for _, id := range ids {
+			logoutExclusively(id)
+		}
+		logger.Info().Int("sessions", len(ids)).Msg("Front-channel logout completed successfully")
+
+		// IdPのページに埋め込まれるため、表示する内容は何も無い
+		// キャッシュされるとログアウトが行われなくなるが、それはnoCacheMiddlewareが防いでいる
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte("<!DOCTYPE html><html><head><title>Logged out</title></head><body></body></html>")); err != nil {
+			logger.Error().Err(err).Msg("Failed to write front-channel logout response")
+		}
+	}
+}
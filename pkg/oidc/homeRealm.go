@@ -0,0 +1,59 @@
+package oidc
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/page"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
+)
+
+const homeRealmDiscoveryPath string = "/discover"
+
+// ログインページで入力されたメールアドレスのドメインからプロバイダーを選び、login_hintを付けてログインを開始させる
+// 対応するプロバイダーが無いドメインの場合は、ボタンの一覧から選ばせる
+func createHomeRealmDiscoveryHandler(config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		upstreamRedirectURL := r.Context().Value(redirect.Key{}).(string)
+		email := strings.TrimSpace(r.URL.Query().Get("email"))
+
+		if provider, found := findProviderByEmail(config, email); found {
+			logger.Info().Str("providerID", provider.ID).Msg("Provider was found by home realm discovery")
+			startURL, _ := url.Parse(getStartURL(provider, upstreamRedirectURL))
+			query := startURL.Query()
+			query.Set("login_hint", email)
+			startURL.RawQuery = query.Encode()
+			http.Redirect(w, r, startURL.String(), http.StatusFound)
+			return
+		}
+
+		logger.Info().Msg("Provider was not found by home realm discovery")
+		data := getTemplateData(config, upstreamRedirectURL)
+		data.Email = email
+		data.Message = i18n.Message(r, "login.unknownDomain", email)
+		page.RenderLogin(w, r, http.StatusOK, data)
+	}
+}
+
+func findProviderByEmail(config Config, email string) (*Provider, bool) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil, false
+	}
+	domain := strings.ToLower(email[at+1:])
+	providerID, found := config.emailDomains[domain]
+	if !found {
+		return nil, false
+	}
+	for _, provider := range config.providers {
+		if provider.ID == providerID {
+			return provider, true
+		}
+	}
+	return nil, false
+}
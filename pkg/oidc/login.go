@@ -1,28 +1,16 @@
 package oidc
 
 import (
-	"embed"
-	"html/template"
 	"net/http"
 	"net/url"
 
 	"github.com/rs/zerolog"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/page"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
 )
 
-//go:embed login_page.html
-var loginPageHTML embed.FS
-
-type templateData struct {
-	Providers []struct {
-		ID          string
-		LoginURL    string
-		RedirectURL string
-	}
-}
-
 func NewLoginHandler(config Config) http.Handler {
 	return noCacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
@@ -30,58 +18,85 @@ func NewLoginHandler(config Config) http.Handler {
 
 		logger.Debug().Msg("Handling new login request")
 
+		if config.unauthorizedJSON && isAPIRequest(r) {
+			logger.Info().Msg("Returning 401 to API request instead of redirecting to IdP")
+			writeUnauthorizedJSON(w, r)
+			return
+		}
+
 		if config.skipLoginPage {
 			logger.Info().Msg("Skipping login page and redirecting to IdP")
-			baseURL := proxyURL.GetURLFromPath(Path + config.providers[0].StartPath)
-			query := url.Values{}
-			query.Set("redirect", upstreamRedirectURL)
-			baseURL.RawQuery = query.Encode()
-			http.Redirect(w, r, baseURL.String(), http.StatusFound)
+			http.Redirect(w, r, getStartURL(config.providers[0], upstreamRedirectURL), http.StatusFound)
 			return
 		}
 
 		logger.Debug().Msg("Rendering login page")
+		page.RenderLogin(w, r, http.StatusOK, getTemplateData(config, upstreamRedirectURL))
+	}))
+}
 
-		tmpl, err := template.ParseFS(loginPageHTML, "login_page.html")
-		if err != nil {
-			logger.Error().Err(err).Msg("Failed to parse login page template")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+// フォワード認証で未ログインの場合に使う
+// nginxのauth_requestは2xx、401および403以外を受け付けないため、IdPへリダイレクトせずに401でログインページを返す
+// TraefikやCaddyはこのレスポンスをそのままブラウザに返すため、ログインページから元のリソースに戻れる
+func NewUnauthorizedHandler(config Config) http.Handler {
+	return noCacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		upstreamRedirectURL := r.Context().Value(redirect.Key{}).(string)
+
+		if config.unauthorizedJSON && isAPIRequest(r) {
+			logger.Info().Msg("Returning 401 JSON to unauthenticated API request")
+			writeUnauthorizedJSON(w, r)
 			return
 		}
 
-		data := getTemplateData(config.providers, upstreamRedirectURL)
+		logger.Info().Msg("Returning 401 with login page to unauthenticated request")
+		page.RenderLogin(w, r, http.StatusUnauthorized, getTemplateData(config, upstreamRedirectURL))
+	}))
+}
+
+// Envoyのext_authzで未ログインの場合に使う
+// Envoyは200以外のレスポンスをそのままクライアントに返すため、ブラウザはログインページにリダイレクトさせる
+func NewSignInRedirectHandler(config Config) http.Handler {
+	return noCacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		upstreamRedirectURL := r.Context().Value(redirect.Key{}).(string)
 
-		err = tmpl.Execute(w, data)
-		if err != nil {
-			logger.Error().Err(err).Msg("Failed to execute login page template")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		if config.unauthorizedJSON && isAPIRequest(r) {
+			logger.Info().Msg("Returning 401 JSON to unauthenticated API request")
+			writeUnauthorizedJSON(w, r)
 			return
 		}
 
-		logger.Info().Msg("Login page rendered successfully")
+		signInURL := proxyURL.GetURLFromPath(Path + signInPath)
+		if upstreamRedirectURL != "" {
+			query := url.Values{}
+			query.Set("rd", upstreamRedirectURL)
+			signInURL.RawQuery = query.Encode()
+		}
+
+		logger.Info().Msg("Redirecting unauthenticated request to sign in page")
+		http.Redirect(w, r, signInURL.String(), http.StatusFound)
 	}))
 }
 
-func getTemplateData(providers []Provider, upstreamRedirectURL string) templateData {
-	var providersData []struct {
-		ID          string
-		LoginURL    string
-		RedirectURL string
-	}
-	for _, provider := range providers {
+func getTemplateData(config Config, upstreamRedirectURL string) page.LoginData {
+	providersData := make([]page.LoginProvider, 0)
+	for _, provider := range config.providers {
 		baseURL := proxyURL.GetURLFromPath(Path + provider.StartPath)
-		loginURL := baseURL.String()
-		providersData = append(providersData, struct {
-			ID          string
-			LoginURL    string
-			RedirectURL string
-		}{
+		providersData = append(providersData, page.LoginProvider{
 			ID:          provider.ID,
-			LoginURL:    loginURL,
+			DisplayName: provider.DisplayName,
+			IconURL:     provider.IconURL,
+			LoginURL:    baseURL.String(),
 			RedirectURL: upstreamRedirectURL,
 		})
 	}
-	return templateData{
-		Providers: providersData,
+	data := page.LoginData{
+		Providers:   providersData,
+		RedirectURL: upstreamRedirectURL,
+	}
+	if len(config.emailDomains) > 0 {
+		data.HomeRealmDiscoveryURL = proxyURL.GetURLFromPath(Path + homeRealmDiscoveryPath).String()
 	}
+	return data
 }
@@ -0,0 +1,106 @@
+package oidc
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/page"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+)
+
+const signOutPath string = "/sign_out"
+
+// 他のサイトに埋め込まれた画像などのGETでログアウトさせられないよう、POSTのみを受け付ける
+func createSignOutHandler(config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		id := r.Context().Value(sessionid.Key{}).(sessionid.ID)
+		postLogoutRedirectURL := r.Context().Value(redirect.Key{}).(string)
+
+		logger.Debug().Msg("Starting sign out process")
+
+		if r.Method != http.MethodPost {
+			logger.Warn().Str("method", r.Method).Msg("Sign out endpoint only accepts POST")
+			w.Header().Set("Allow", http.MethodPost)
+			i18n.Error(w, r, "error.methodNotAllowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !isSignOutFromAllowedOrigin(r) {
+			logger.Warn().Str("origin", r.Header.Get("Origin")).Str("secFetchSite", r.Header.Get("Sec-Fetch-Site")).Msg("Sign out request from another site was rejected")
+			i18n.Error(w, r, "error.crossSiteSignOut", http.StatusForbidden)
+			return
+		}
+
+		if postLogoutRedirectURL != "" && !slices.Contains(config.postLogoutRedirectURLs, postLogoutRedirectURL) {
+			logger.Error().Str("postLogoutRedirectURL", postLogoutRedirectURL).Msg("Post logout redirect URL is not allowed")
+			i18n.Error(w, r, "error.postLogoutRedirectNotAllowed", http.StatusBadRequest)
+			return
+		}
+
+		// IdPへのログアウト要求に必要な情報は、セッションを破棄する前に取り出しておく
+		providerID, _ := session.GetProviderID(id)
+		rawIDToken, _ := session.GetRawIDToken(id)
+
+		logoutExclusively(id)
+		sessionid.ExpireCookie(w)
+		logger.Info().Msg("Session was destroyed")
+
+		if provider, found := findProvider(config, providerID); found && provider.EndSessionEndpoint != "" {
+			endSessionURL, err := getEndSessionURL(provider, rawIDToken, postLogoutRedirectURL)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to create end session URL")
+				i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
+				return
+			}
+			logger.Info().Msg("Redirect to OIDC provider's end session endpoint")
+			http.Redirect(w, r, endSessionURL, http.StatusFound)
+			return
+		}
+
+		if postLogoutRedirectURL != "" {
+			http.Redirect(w, r, postLogoutRedirectURL, http.StatusFound)
+			return
+		}
+
+		page.RenderSignedOut(w, r)
+	}
+}
+
+// Cookieが送られないクロスサイトのPOSTでも、ログアウトのレスポンスでセッションのCookieは削除されてしまうため、送信元のサイトを確認する
+// Originがあれば、ログイン後のリダイレクト先と同じく、プロキシ自体と許可されたホストからのみ受け付ける
+// Originが無い場合はSec-Fetch-Siteで判定し、どちらも無い場合はブラウザ以外からのリクエストとして受け付ける
+func isSignOutFromAllowedOrigin(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return redirect.IsValidURL(origin)
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+		return true
+	}
+	return false
+}
+
+func getEndSessionURL(provider *Provider, rawIDToken string, postLogoutRedirectURL string) (string, error) {
+	endSessionURL, err := url.Parse(provider.EndSessionEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := endSessionURL.Query()
+	if rawIDToken != "" {
+		query.Set("id_token_hint", rawIDToken)
+	}
+	// id_token_hintが無い場合でもIdPがRPを特定できるように、client_idも送る
+	query.Set("client_id", provider.OAuth2Config.ClientID)
+	if postLogoutRedirectURL != "" {
+		query.Set("post_logout_redirect_uri", postLogoutRedirectURL)
+	}
+	endSessionURL.RawQuery = query.Encode()
+	return endSessionURL.String(), nil
+}
@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
+)
+
+func TestIsSignOutFromAllowedOrigin(t *testing.T) {
+	proxyURL.Init(proxyURL.Config{Host: "proxy.example.com"})
+	redirect.Init(redirect.Config{AllowedHosts: []string{"app.example.com"}})
+
+	tests := []struct {
+		name         string
+		origin       string
+		secFetchSite string
+		want         bool
+	}{
+		{"proxy origin", "https://proxy.example.com", "same-origin", true},
+		{"allowed origin", "https://app.example.com", "same-site", true},
+		{"cross site origin", "https://evil.com", "cross-site", false},
+		{"opaque origin", "null", "cross-site", false},
+		{"same origin without Origin", "", "same-origin", true},
+		{"cross site without Origin", "", "cross-site", false},
+		{"same site without Origin", "", "same-site", false},
+		{"user initiated without Origin", "", "none", true},
+		{"non browser client", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://proxy.example.com/oauth2/sign_out", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.secFetchSite != "" {
+				r.Header.Set("Sec-Fetch-Site", tt.secFetchSite)
+			}
+			if got := isSignOutFromAllowedOrigin(r); got != tt.want {
+				t.Errorf("isSignOutFromAllowedOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
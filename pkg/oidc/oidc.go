@@ -2,13 +2,19 @@ package oidc
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/crypto"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
@@ -19,16 +25,31 @@ import (
 
 const Path string = "/oauth2"
 
+const signInPath string = "/sign_in"
+
 func NewRouter(config Config) *chi.Mux {
 	r := chi.NewRouter()
 	r.Use(noCacheMiddleware)
 	for _, provider := range config.providers {
-		r.Handle(provider.StartPath, createOIDCStartHandler(provider))
-		r.Handle(getCallbackPath(provider), createOIDCCallbackHandler(provider))
+		r.Handle(provider.StartPath, requireReady(provider, createOIDCStartHandler(provider)))
+		r.Handle(getCallbackPath(provider), requireReady(provider, createOIDCCallbackHandler(provider)))
+		if provider.BackChannelLogoutPath != "" {
+			r.Handle(provider.BackChannelLogoutPath, requireReady(provider, createBackChannelLogoutHandler(provider)))
+		}
+		if provider.FrontChannelLogoutPath != "" {
+			r.Handle(provider.FrontChannelLogoutPath, requireReady(provider, createFrontChannelLogoutHandler(provider)))
+		}
+	}
+	r.Handle(signOutPath, createSignOutHandler(config))
+	r.Handle(providersPath, createProvidersHandler(config))
+	// フォワード認証で401を受け取ったリバースプロキシは、rdに元のURLを付けてここへリダイレクトさせる
+	r.Handle(signInPath, redirect.ForwardedMiddleware(NewLoginHandler(config)))
+	if len(config.emailDomains) > 0 {
+		r.Handle(homeRealmDiscoveryPath, createHomeRealmDiscoveryHandler(config))
 	}
 	return r
 }
-func createOIDCStartHandler(provider Provider) http.HandlerFunc {
+func createOIDCStartHandler(provider *Provider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
 		id := r.Context().Value(sessionid.Key{}).(sessionid.ID)
@@ -36,8 +57,9 @@ func createOIDCStartHandler(provider Provider) http.HandlerFunc {
 
 		redirectValue := r.Context().Value(redirect.Key{})
 
-		// 新規ログインなので、古い情報は削除してよい
-		logoutCompletely(id)
+		// 古いログイン情報は、新しいログインがコールバックで検証されるまで残しておく
+		// Step-upではユーザーがIdPでキャンセルしても、それまでのセッションで他のUpstreamを使い続けられる
+		// 他のタブで実行中のフローも、それぞれのコールバックで完了できるように残しておく
 
 		var redirectURL string
 		if redirectValue != nil {
@@ -46,35 +68,87 @@ func createOIDCStartHandler(provider Provider) http.HandlerFunc {
 			// 通常、子のハンドラへのアクセスはUpstreamへのアクセスがリダイレクトされる形で行われる
 			// しかし、理論的には直接OIDC認証を始めるためのエンドポイントをたたくことも出来る
 			logger.Error().Msg("Authentication request without upstream redirect URL - denied")
-			http.Error(w, "Authentication request without upstream redirect URL is not allowed", http.StatusBadRequest)
+			i18n.Error(w, r, "error.noRedirectURL", http.StatusBadRequest)
 			return
 		}
 
 		state, err := createState()
 		if err != nil {
 			logger.Error().Err(err).Msg("Failed to create state for OIDC authentication")
-			http.Error(w, "Internal error", http.StatusInternalServerError)
+			i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
 			return
 		}
 
 		nonce, err := createNonce()
 		if err != nil {
 			logger.Error().Err(err).Msg("Failed to create nonce for OIDC authentication")
-			http.Error(w, "Internal error", http.StatusInternalServerError)
+			i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
 			return
 		}
 
-		session.SetState(id, state)
-		session.SetNonce(id, nonce)
-		session.SetRedirectURL(id, redirectURL)
+		binding, err := setFlowBindingCookie(w, r)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to create flow binding for OIDC authentication")
+			i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
+			return
+		}
+
+		flow := session.Flow{
+			State:       state,
+			SessionID:   id,
+			Binding:     binding,
+			ProviderID:  provider.ID,
+			Nonce:       nonce,
+			RedirectURL: redirectURL,
+		}
 
-		authEndpointURL := provider.OAuth2Config.AuthCodeURL(
-			state,
-			oidc.Nonce(nonce),
+		opts := []oauth2.AuthCodeOption{
 			// OAuth2.0ではredirect_uriの指定はOPTIONALだが、
 			// oauth2proxyは複数のredirect_uriが使われるIdPと通信することがあるため必須である
 			oauth2.SetAuthURLParam("redirect_uri", provider.OAuth2Config.RedirectURL),
-		)
+		}
+
+		// Home Realm Discoveryで入力されたメールアドレスを、IdPのログイン画面に引き継ぐ
+		if loginHint := r.URL.Query().Get("login_hint"); loginHint != "" {
+			opts = append(opts, oauth2.SetAuthURLParam("login_hint", loginHint))
+		}
+
+		if provider.ResponseMode != "" {
+			opts = append(opts, oauth2.SetAuthURLParam("response_mode", provider.ResponseMode))
+		}
+
+		// nonceはIDトークンに含めてもらうものなので、IDトークンを発行しないOAuth2のプロバイダーには送らない
+		if provider.Type == ProviderTypeOIDC {
+			opts = append(opts, oidc.Nonce(nonce))
+		}
+
+		if provider.PKCE {
+			codeVerifier := oauth2.GenerateVerifier()
+			flow.CodeVerifier = codeVerifier
+			opts = append(opts, oauth2.S256ChallengeOption(codeVerifier))
+		}
+
+		// Step-upの場合は、Upstreamの要求を満たす認証をIdPに求め、既存のIdPのセッションを使わせない
+		if requirement, ok := r.Context().Value(identity.RequirementKey{}).(identity.Requirement); ok && !requirement.IsEmpty() {
+			flow.Requirement = requirement
+			opts = append(opts, getRequirementOptions(requirement)...)
+		}
+
+		authEndpointURL := provider.OAuth2Config.AuthCodeURL(state, opts...)
+		if provider.PushedAuthorizationRequestEndpoint != "" {
+			authEndpointURL, err = provider.pushAuthorizationRequest(context.Background(), authEndpointURL)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to push authorization request")
+				renderErrorPage(w, r, http.StatusBadGateway, getPARErrorMessage(r, provider, err))
+				return
+			}
+		}
+
+		if err := session.AddFlow(flow); err != nil {
+			logger.Error().Err(err).Msg("Failed to save authentication flow")
+			i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
+			return
+		}
 
 		logger.Info().
 			Str("state", state).
@@ -84,6 +158,19 @@ func createOIDCStartHandler(provider Provider) http.HandlerFunc {
 		http.Redirect(w, r, authEndpointURL, http.StatusFound)
 	}
 }
+func getRequirementOptions(requirement identity.Requirement) []oauth2.AuthCodeOption {
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("prompt", "login"),
+	}
+	if len(requirement.ACRValues) > 0 {
+		opts = append(opts, oauth2.SetAuthURLParam("acr_values", strings.Join(requirement.ACRValues, " ")))
+	}
+	if requirement.MaxAuthAge > 0 {
+		opts = append(opts, oauth2.SetAuthURLParam("max_age", strconv.Itoa(int(requirement.MaxAuthAge.Seconds()))))
+	}
+	return opts
+}
+
 func createNonce() (string, error) {
 	return crypto.RandString(16)
 }
@@ -92,115 +179,213 @@ func createState() (string, error) {
 	return crypto.RandString(16)
 }
 
-func getCallbackPath(provider Provider) string {
-	return strings.TrimPrefix(proxyURL.GetPathFromURL(provider.OAuth2Config.RedirectURL), Path)
+// ログインを開始するURL。redirectにログイン後の戻り先を指定する
+func getStartURL(provider *Provider, redirectURL string) string {
+	startURL := proxyURL.GetURLFromPath(Path + provider.StartPath)
+	query := url.Values{}
+	query.Set("redirect", redirectURL)
+	startURL.RawQuery = query.Encode()
+	return startURL.String()
+}
+
+func getCallbackPath(provider *Provider) string {
+	return strings.TrimPrefix(proxyURL.GetPathFromURL(provider.RedirectURL), Path)
 }
 
-func createOIDCCallbackHandler(provider Provider) http.HandlerFunc {
+func createOIDCCallbackHandler(provider *Provider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
-		id := r.Context().Value(sessionid.Key{}).(sessionid.ID)
 
 		logger.Debug().Msg("Starting OIDC callback process")
 
-		// OIDCの仕様により、StateをNonceは使ったらすぐに破棄する
-		// RedirectURLも保持しておく理由がないため過ぎに破棄する
-		defer func() {
-			session.DeleteState(id)
-			session.DeleteNonce(id)
-			session.DeleteRedirectURL(id)
-		}()
-
-		// 再びログインを行おうとしているので、古いログイン情報は削除する
-		// StateとNonceとRedirectURLは上のdefer節で消してくれるためlogoutでは消さなくてよい
-		logout(id)
-
-		state, err := session.GetState(id)
-		if err != nil {
-			logger.Error().Err(err).Msg("State not found during OIDC callback")
-			http.Error(w, "state not found", http.StatusBadRequest)
+		// 複数のIdPを使う場合、別のIdPの認可レスポンスがこのコールバックに届くMix-Up攻撃を防ぐ
+		// RFC 9207に従い、エラーレスポンスを含む全ての認可レスポンスで、他の処理より先に確認する
+		if err := checkResponseIssuer(r, provider); err != nil {
+			logger.Error().
+				Err(err).
+				Bool("securityEvent", true).
+				Str("expectedIssuer", provider.Issuer).
+				Str("responseIssuer", r.FormValue("iss")).
+				Msg("Authorization response issuer did not match during OIDC callback")
+			renderErrorPageWithRetry(w, r, http.StatusBadRequest, i18n.Message(r, "error.issuerMismatch"), getCallbackRetryURL(provider, nil))
 			return
 		}
-		if r.URL.Query().Get("state") != state {
-			logger.Error().Msg("State did not match during OIDC callback")
-			http.Error(w, "state did not match", http.StatusBadRequest)
+
+		if r.FormValue("error") != "" {
+			handleAuthorizationError(w, r, provider)
 			return
 		}
 
-		oauth2Token, err := provider.OAuth2Config.Exchange(context.Background(), r.URL.Query().Get("code"))
+		state := r.FormValue("state")
+		flow, err := session.GetFlow(state)
 		if err != nil {
-			logger.Error().Err(err).Msg("Failed to exchange token during OIDC callback")
-			http.Error(w, "Failed to exchange token: "+err.Error(), http.StatusInternalServerError)
+			logger.Error().Err(err).Msg("State not found during OIDC callback")
+			renderErrorPageWithRetry(w, r, http.StatusBadRequest, i18n.Message(r, "error.stateNotFound"), getCallbackRetryURL(provider, nil))
 			return
 		}
+		// OIDCの仕様により、StateとNonceは使ったらすぐに破棄する
+		// RedirectURLも保持しておく理由がないため、フローごとすぐに破棄する
+		defer session.DeleteFlow(state)
 
-		rawIDToken, ok := oauth2Token.Extra("id_token").(string)
-		if !ok {
-			logger.Error().Msg("No id_token field in oauth2 token during OIDC callback")
-			http.Error(w, "No id_token field in oauth2 token.", http.StatusInternalServerError)
+		if err := checkFlow(r, provider, flow); err != nil {
+			logger.Error().Err(err).Msg("State did not match during OIDC callback")
+			// 別のブラウザで開始されたフローの可能性があるため、そのフローの戻り先は使わない
+			renderErrorPageWithRetry(w, r, http.StatusBadRequest, i18n.Message(r, "error.stateMismatch"), getCallbackRetryURL(provider, nil))
 			return
 		}
+		id := flow.SessionID
 
-		idToken, err := provider.Verifier.Verify(context.Background(), rawIDToken)
-		if err != nil {
-			logger.Error().Err(err).Msg("Failed to verify ID Token during OIDC callback")
-			http.Error(w, "Failed to verify ID Token: "+err.Error(), http.StatusInternalServerError)
-			return
+		exchangeOpts := make([]oauth2.AuthCodeOption, 0)
+		if provider.PKCE {
+			if flow.CodeVerifier == "" {
+				logger.Error().Msg("Code verifier not found during OIDC callback")
+				renderErrorPageWithRetry(w, r, http.StatusBadRequest, i18n.Message(r, "error.codeVerifierNotFound"), getCallbackRetryURL(provider, &flow))
+				return
+			}
+			exchangeOpts = append(exchangeOpts, oauth2.VerifierOption(flow.CodeVerifier))
 		}
 
-		nonce, err := session.GetNonce(id)
+		oauth2Token, err := provider.OAuth2Config.Exchange(provider.clientAuthContext(context.Background()), r.FormValue("code"), exchangeOpts...)
 		if err != nil {
-			logger.Error().Err(err).Msg("Nonce not found during OIDC callback")
-			http.Error(w, "nonce not found", http.StatusBadRequest)
-			return
-		}
-		if idToken.Nonce != nonce {
-			logger.Error().Msg("Nonce did not match during OIDC callback")
-			http.Error(w, "nonce did not match", http.StatusBadRequest)
+			logger.Error().Err(err).Msg("Failed to exchange token during OIDC callback")
+			renderErrorPageWithRetry(w, r, http.StatusBadGateway, i18n.Message(r, "error.exchangeFailed", provider.DisplayName), getCallbackRetryURL(provider, &flow))
 			return
 		}
 
-		userInfo, err := provider.OIDCProvider.UserInfo(context.Background(), oauth2.StaticTokenSource(oauth2Token))
-		if err != nil {
-			logger.Error().Err(err).Msg("Failed to get userInfo during OIDC callback")
-			http.Error(w, "Failed to get userInfo: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+		var rawIDToken string
+		var idToken *oidc.IDToken
+		var userInfo *oidc.UserInfo
+		var profile identity.JSONClaims
+		if provider.Type == ProviderTypeOAuth2 {
+			// IDトークンが無いため、プロフィールのエンドポイントからユーザーを識別する
+			profile, err = fetchProfile(context.Background(), provider, oauth2Token)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to get profile during OAuth2 callback")
+				renderErrorPageWithRetry(w, r, http.StatusBadGateway, i18n.Message(r, "error.profileFailed"), getCallbackRetryURL(provider, &flow))
+				return
+			}
+		} else {
+			var ok bool
+			rawIDToken, ok = oauth2Token.Extra("id_token").(string)
+			if !ok {
+				logger.Error().Msg("No id_token field in oauth2 token during OIDC callback")
+				renderErrorPageWithRetry(w, r, http.StatusBadGateway, i18n.Message(r, "error.noIDToken"), getCallbackRetryURL(provider, &flow))
+				return
+			}
 
-		redirectURL, err := session.GetRedirectURL(id)
-		if err != nil {
-			logger.Error().Err(err).Msg("Failed to get redirect URL during OIDC callback")
-			http.Error(w, "Failed to get redirect URL: "+err.Error(), http.StatusInternalServerError)
-			return
+			idToken, err = provider.Verifier.Verify(context.Background(), rawIDToken)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to verify ID Token during OIDC callback")
+				renderErrorPageWithRetry(w, r, http.StatusBadGateway, i18n.Message(r, "error.idTokenInvalid"), getCallbackRetryURL(provider, &flow))
+				return
+			}
+
+			if idToken.Nonce != flow.Nonce {
+				logger.Error().Msg("Nonce did not match during OIDC callback")
+				renderErrorPageWithRetry(w, r, http.StatusBadRequest, i18n.Message(r, "error.nonceMismatch"), getCallbackRetryURL(provider, &flow))
+				return
+			}
+
+			// IdPはacr_valuesやmax_ageを満たせなくても認証を成功させることがあるため、結果を検証する
+			if err := flow.Requirement.Check(idToken, time.Now()); err != nil {
+				logger.Error().Err(err).Msg("Authentication did not satisfy the requirement during OIDC callback")
+				// 元のページにもう一度アクセスすれば、Step-upをやり直せる
+				renderErrorPageWithRetry(w, r, http.StatusForbidden, i18n.Message(r, "error.requirementNotSatisfied"), flow.RedirectURL)
+				return
+			}
+
+			userInfo, err = provider.OIDCProvider.UserInfo(context.Background(), oauth2.StaticTokenSource(oauth2Token))
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to get userInfo during OIDC callback")
+				renderErrorPageWithRetry(w, r, http.StatusBadGateway, i18n.Message(r, "error.userInfoFailed"), getCallbackRetryURL(provider, &flow))
+				return
+			}
 		}
 
+		// 新しいログインを全て検証できたので、ここで初めて古いログイン情報を削除する
+		// 検証に失敗した不正なレスポンスで、ログイン中のユーザーがログアウトさせられないようにするため
+		// 実行中のフローは上のdefer節で消してくれるため、logoutでは消さなくてよい
+		logout(id)
+
 		// セッションハイジャックを防ぐため、ログインに成功したらセッションIDを再発行する
 		newID, err := sessionid.RefreshSession(w, r)
 		session.RefreshSession(id, newID)
 		if err != nil {
 			logger.Error().Err(err).Msg("Failed to refresh session during OIDC callback")
-			http.Error(w, "Failed to refresh session: "+err.Error(), http.StatusInternalServerError)
+			renderErrorPageWithRetry(w, r, http.StatusInternalServerError, i18n.Message(r, "error.sessionRefreshFailed"), getCallbackRetryURL(provider, &flow))
 			return
 		}
 
 		// この処理を最後に置いているのは、不正なログインを防ぐため
 		// 正しくログインを検証できたときのみセッションに情報を保持する
-		session.SetIDToken(newID, idToken)
-		session.SetUserInfo(newID, userInfo)
+		if provider.Type == ProviderTypeOAuth2 {
+			session.SetProfile(newID, profile)
+		} else {
+			session.SetIDToken(newID, idToken)
+			session.SetRawIDToken(newID, rawIDToken)
+			session.SetUserInfo(newID, userInfo)
+		}
+		session.SetToken(newID, oauth2Token)
+		session.SetProviderID(newID, provider.ID)
+		session.SetExpiry(newID, getTokenExpiry(oauth2Token, idToken))
 
 		logger.Info().Msg("OIDC callback process completed successfully")
-		http.Redirect(w, r, redirectURL, http.StatusFound)
+		http.Redirect(w, r, flow.RedirectURL, http.StatusFound)
+	}
+}
+
+// コールバックのエラーから、ログインをやり直すためのURL
+// フローが分からない場合は戻り先も分からないため、プロキシのトップページからやり直させる
+func getCallbackRetryURL(provider *Provider, flow *session.Flow) string {
+	if flow == nil {
+		return proxyURL.GetURLFromPath("/").String()
+	}
+	return getStartURL(provider, flow.RedirectURL)
+}
+
+// IdPがissパラメータに対応している場合は必須とし、付与されていれば常にIssuerと比較する(RFC 9207)
+func checkResponseIssuer(r *http.Request, provider *Provider) error {
+	iss := r.FormValue("iss")
+	if iss == "" {
+		if provider.ISSParameterSupported {
+			return errors.New("error: iss parameter is missing in authorization response")
+		}
+		return nil
+	}
+	// OAuth2のプロバイダーはIssuerを持たないため、比較できない
+	if provider.Issuer != "" && iss != provider.Issuer {
+		return errors.New("error: iss parameter does not match the issuer of the provider")
+	}
+	return nil
+}
+
+// form_postのコールバックはIdPからのクロスサイトのPOSTであり、SameSite=LaxのCookieが送られないため、フローはstateから探す
+// どちらの応答モードでも、フローがこのブラウザで開始されたものであることをflow_bindingのCookieで確認する
+// queryのコールバックではセッションのCookieも送られるため、セッションが同じであることも確認する
+func checkFlow(r *http.Request, provider *Provider, flow session.Flow) error {
+	if flow.ProviderID != provider.ID {
+		return errors.New("error: flow was started with another provider")
+	}
+	if err := checkFlowBinding(r, flow.Binding); err != nil {
+		return err
+	}
+	if provider.ResponseMode != responseModeFormPost && flow.SessionID != r.Context().Value(sessionid.Key{}).(sessionid.ID) {
+		return errors.New("error: flow was started in another session")
 	}
+	return nil
 }
 
 func logoutCompletely(id sessionid.ID) {
 	logout(id)
-	session.DeleteNonce(id)
-	session.DeleteRedirectURL(id)
-	session.DeleteState(id)
+	session.DeleteFlows(id)
 }
 
 func logout(id sessionid.ID) {
 	session.DeleteIDToken(id)
 	session.DeleteUserInfo(id)
+	session.DeleteRawIDToken(id)
+	session.DeleteProfile(id)
+	session.DeleteToken(id)
+	session.DeleteProviderID(id)
+	session.DeleteExpiry(id)
 }
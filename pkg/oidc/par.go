@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"golang.org/x/oauth2"
+)
+
+// PARはログイン開始のリクエストの中で行うため、応答しないエンドポイントを待ち続けずにエラーページを返せるよう上限を設ける
+const parTimeout time.Duration = 10 * time.Second
+
+type parResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int    `json:"expires_in"`
+}
+
+// PARエンドポイントが返したエラー。エラーページに表示するため、IdPのエラーコードを保持する
+type parError struct {
+	StatusCode       int
+	Code             string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (e *parError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("error: pushed authorization request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("error: pushed authorization request failed: %s: %s", e.Code, e.ErrorDescription)
+}
+
+// 認可リクエストのパラメータをPARエンドポイントへ送り、ブラウザのリダイレクト先にはclient_idとrequest_uriのみを載せる
+// パラメータはAuthCodeURLで組み立てたURLから取り出すため、通常の認可リクエストと同じ内容になる
+func (p *Provider) pushAuthorizationRequest(ctx context.Context, authCodeURL string) (string, error) {
+	u, err := url.Parse(authCodeURL)
+	if err != nil {
+		return "", err
+	}
+	params := u.Query()
+
+	ctx, cancel := context.WithTimeout(ctx, parTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.PushedAuthorizationRequestEndpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// JWTによるクライアント認証とパブリッククライアントは、clientSecretを持たない
+	if p.OAuth2Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.OAuth2Config.ClientID), url.QueryEscape(p.OAuth2Config.ClientSecret))
+	}
+
+	client := http.DefaultClient
+	if c, ok := p.clientAuthContext(ctx).Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = c
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		parErr := &parError{StatusCode: resp.StatusCode}
+		// エラーのJSONを読めなくても、ステータスコードだけでエラーとして扱う
+		json.NewDecoder(resp.Body).Decode(parErr)
+		return "", parErr
+	}
+
+	var parResp parResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parResp); err != nil {
+		return "", err
+	}
+	if parResp.RequestURI == "" {
+		return "", errors.New("error: pushed authorization response has no request_uri")
+	}
+
+	authURL, err := url.Parse(p.OAuth2Config.Endpoint.AuthURL)
+	if err != nil {
+		return "", err
+	}
+	query := authURL.Query()
+	query.Set("client_id", p.OAuth2Config.ClientID)
+	query.Set("request_uri", parResp.RequestURI)
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+func getPARErrorMessage(r *http.Request, provider *Provider, err error) string {
+	var parErr *parError
+	if errors.As(err, &parErr) && parErr.Code != "" {
+		return i18n.Message(r, "error.parFailedWithCode", provider.DisplayName, parErr.Code)
+	}
+	return i18n.Message(r, "error.parFailed", provider.DisplayName)
+}
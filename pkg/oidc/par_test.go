@@ -0,0 +1,77 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestPushAuthorizationRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		wantURL  string
+		wantCode string
+		wantErr  bool
+	}{
+		{
+			name: "created",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"request_uri":"urn:example:1","expires_in":60}`))
+			},
+			wantURL: "https://idp.example.com/authorize?client_id=client&request_uri=urn%3Aexample%3A1",
+		},
+		{
+			name: "error response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_request"}`))
+			},
+			wantCode: "invalid_request",
+			wantErr:  true,
+		},
+		{
+			name: "no request_uri",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{}`))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			provider := newPARTestProvider(server.URL)
+
+			got, err := provider.pushAuthorizationRequest(context.Background(), "https://idp.example.com/authorize?client_id=client&state=s")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pushAuthorizationRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantURL {
+				t.Errorf("pushAuthorizationRequest() = %q, want %q", got, tt.wantURL)
+			}
+			var parErr *parError
+			if tt.wantCode != "" && (!errors.As(err, &parErr) || parErr.Code != tt.wantCode) {
+				t.Errorf("pushAuthorizationRequest() error = %v, want code %q", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func newPARTestProvider(endpoint string) *Provider {
+	return &Provider{
+		PushedAuthorizationRequestEndpoint: endpoint,
+		OAuth2Config: &oauth2.Config{
+			ClientID: "client",
+			Endpoint: oauth2.Endpoint{AuthURL: "https://idp.example.com/authorize"},
+		},
+	}
+}
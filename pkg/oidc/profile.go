@@ -0,0 +1,79 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
+	"golang.org/x/oauth2"
+)
+
+// ClaimMappingで指定されていなくても、同じ名前のフィールドから取得を試みるクレーム
+var standardClaims = []string{
+	"sub", "name", "family_name", "given_name", "middle_name",
+	"nickname", "preferred_username", "profile", "picture",
+	"website", "gender", "birthdate", "zoneinfo",
+	"locale", "updated_at", "email", "email_verified",
+	"address", "phone_number", "phone_number_verified",
+}
+
+// OAuth2のプロバイダーのプロフィールを取得し、標準クレームの形に変換する
+func fetchProfile(ctx context.Context, provider *Provider, token *oauth2.Token) (identity.JSONClaims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.ProfileEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := provider.OAuth2Config.Client(ctx, token).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error: profile endpoint returned status %d", resp.StatusCode)
+	}
+
+	var profile map[string]any
+	decoder := json.NewDecoder(resp.Body)
+	// GitHubのidのような数値のIDを、精度を落とさずに文字列へ変換するため
+	decoder.UseNumber()
+	if err := decoder.Decode(&profile); err != nil {
+		return nil, err
+	}
+
+	claims := mapProfileClaims(profile, provider.ClaimMapping)
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("error: profile does not contain subject")
+	}
+	return json.Marshal(claims)
+}
+
+func mapProfileClaims(profile map[string]any, mapping map[string]string) map[string]any {
+	claims := make(map[string]any)
+	setClaim := func(claim, path string) {
+		value, found := identity.Lookup(profile, path)
+		if !found || value == nil {
+			return
+		}
+		// ヘッダーの注入などでは文字列として扱うため、数値は文字列に揃えておく
+		if number, ok := value.(json.Number); ok {
+			value = number.String()
+		}
+		claims[claim] = value
+	}
+
+	for _, claim := range standardClaims {
+		if _, mapped := mapping[claim]; !mapped {
+			setClaim(claim, claim)
+		}
+	}
+	for claim, path := range mapping {
+		setClaim(claim, path)
+	}
+	return claims
+}
@@ -0,0 +1,98 @@
+package oidc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
+)
+
+const providersPath string = "/providers"
+
+type providersResponse struct {
+	Providers []providerResponse `json:"providers"`
+}
+
+type providerResponse struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	IconURL     string `json:"iconURL,omitempty"`
+	StartURL    string `json:"startURL"`
+	Ready       bool   `json:"ready"`
+}
+
+type unauthorizedResponse struct {
+	Error        string `json:"error"`
+	Message      string `json:"message"`
+	ProvidersURL string `json:"providersURL"`
+}
+
+// SPAが独自のログイン画面を表示できるように、プロバイダーの一覧をJSONで返す
+// redirectが指定された場合は、ログイン後にそこへ戻るStartURLを返す
+// 指定されていない場合は、SPAがStartURLにredirectを付与してから遷移させる必要がある
+func createProvidersHandler(config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		if r.Method != http.MethodGet {
+			logger.Warn().Str("method", r.Method).Msg("Providers endpoint only accepts GET")
+			w.Header().Set("Allow", http.MethodGet)
+			i18n.Error(w, r, "error.methodNotAllowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		upstreamRedirectURL := r.Context().Value(redirect.Key{}).(string)
+		response := providersResponse{
+			Providers: make([]providerResponse, 0),
+		}
+		for _, provider := range config.providers {
+			startURL := proxyURL.GetURLFromPath(Path + provider.StartPath).String()
+			if upstreamRedirectURL != "" {
+				startURL = getStartURL(provider, upstreamRedirectURL)
+			}
+			response.Providers = append(response.Providers, providerResponse{
+				ID:          provider.ID,
+				DisplayName: provider.DisplayName,
+				IconURL:     provider.IconURL,
+				StartURL:    startURL,
+				Ready:       provider.isReady(),
+			})
+		}
+
+		logger.Debug().Msg("Returning provider list")
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// fetchやXMLHttpRequestはIdPへのリダイレクトを追えないため、ページ遷移ではないリクエストを判定する
+// Sec-Fetch-Destは新しいブラウザのみが、X-Requested-Withは一部のライブラリのみが付与するため、Acceptも確認する
+func isAPIRequest(r *http.Request) bool {
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+	if dest := r.Header.Get("Sec-Fetch-Dest"); dest != "" {
+		return dest == "empty"
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// APIのURLはログイン後の戻り先にならないため、providersURLにはredirectを付与しない
+func writeUnauthorizedJSON(w http.ResponseWriter, r *http.Request) {
+	providersURL := proxyURL.GetURLFromPath(Path + providersPath)
+	writeJSON(w, http.StatusUnauthorized, unauthorizedResponse{
+		Error:        "unauthorized",
+		Message:      i18n.Message(r, "error.unauthorized"),
+		ProvidersURL: providersURL.String(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
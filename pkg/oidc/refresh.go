@@ -0,0 +1,233 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+	"golang.org/x/oauth2"
+)
+
+// トークンの有効期限のこの時間前になったらリフレッシュする
+// 期限ちょうどに更新すると、Upstreamに届く前にトークンが失効する可能性があるため余裕を持たせる
+const refreshMargin time.Duration = 1 * time.Minute
+
+// リフレッシュの間は同じセッションの他のリクエストも待たされるため、応答しないIdPを待ち続けないよう上限を設ける
+const refreshTimeout time.Duration = 30 * time.Second
+
+// 同じセッションに対する並行したリクエストが同時にリフレッシュを行うと、
+// リフレッシュトークンのローテーションを行うIdPでは後のリフレッシュが失敗してしまうため、セッションごとに排他する
+// 待っているリクエストがいる間にロックを削除すると、別のロックが作られて排他できなくなるため、利用者を数えて誰もいなくなったときのみ削除する
+var refreshLocks = make(map[sessionid.ID]*refreshLock)
+var refreshLocksMutex sync.Mutex
+
+type refreshLock struct {
+	sync.Mutex
+	users int
+}
+
+func lockRefresh(id sessionid.ID) *refreshLock {
+	refreshLocksMutex.Lock()
+	lock, found := refreshLocks[id]
+	if !found {
+		lock = &refreshLock{}
+		refreshLocks[id] = lock
+	}
+	lock.users++
+	refreshLocksMutex.Unlock()
+
+	lock.Lock()
+	return lock
+}
+
+func unlockRefresh(id sessionid.ID, lock *refreshLock) {
+	lock.Unlock()
+
+	refreshLocksMutex.Lock()
+	lock.users--
+	if lock.users == 0 {
+		delete(refreshLocks, id)
+	}
+	refreshLocksMutex.Unlock()
+}
+
+// リフレッシュトークンを持つセッションについて、トークンの有効期限が近ければ更新する
+// ログイン状態の判定より前に置くことで、リフレッシュに失敗して破棄されたセッションは未ログインとして扱われる
+func NewRefreshMiddleware(config Config) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+			id := r.Context().Value(sessionid.Key{}).(sessionid.ID)
+
+			token, err := session.GetToken(id)
+			if err != nil || token.RefreshToken == "" {
+				// リフレッシュトークンを持たないセッションは、従来通り期限が来たら再ログインさせる
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if needsRefresh(id) {
+				logger.Debug().Msg("Refreshing tokens of the session")
+				if err := refreshSessionExclusively(config, id); err != nil {
+					logger.Warn().Err(err).Msg("Failed to refresh tokens. The session was ended.")
+					next.ServeHTTP(w, r)
+					return
+				}
+				sessionid.ExtendSession(w, id)
+				logger.Info().Msg("Tokens of the session were refreshed")
+			} else if session.NeedsExtend(id) {
+				session.ExtendSession(id)
+				sessionid.ExtendSession(w, id)
+				logger.Debug().Msg("Session was extended")
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func needsRefresh(id sessionid.ID) bool {
+	expiry, err := session.GetExpiry(id)
+	if err != nil || expiry.IsZero() {
+		return false
+	}
+	return time.Until(expiry) < refreshMargin
+}
+
+func refreshSessionExclusively(config Config, id sessionid.ID) error {
+	lock := lockRefresh(id)
+	defer unlockRefresh(id, lock)
+
+	// ロックを待っている間に別のリクエストがリフレッシュを終えている可能性がある
+	if !needsRefresh(id) {
+		return nil
+	}
+	// クライアントが切断してもリフレッシュを中断してセッションを失わないよう、リクエストのContextは使わない
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	if err := refreshSession(ctx, config, id); err != nil {
+		// ロックを外してからログアウトすると、その間に別のリクエストが始めたリフレッシュと競合するため、ロックの中でログアウトする
+		logoutCompletely(id)
+		return err
+	}
+	return nil
+}
+
+// ログアウトと進行中のリフレッシュが重なると、リフレッシュがログアウトしたセッションを書き戻してしまうため、リフレッシュと排他する
+func logoutExclusively(id sessionid.ID) {
+	lock := lockRefresh(id)
+	defer unlockRefresh(id, lock)
+	logoutCompletely(id)
+}
+
+func refreshSession(ctx context.Context, config Config, id sessionid.ID) error {
+	providerID, err := session.GetProviderID(id)
+	if err != nil {
+		return err
+	}
+	provider, found := findProvider(config, providerID)
+	if !found {
+		return errors.New("error: provider of the session is no longer configured")
+	}
+	oldToken, err := session.GetToken(id)
+	if err != nil {
+		return err
+	}
+
+	// アクセストークンの期限に関わらず必ずリフレッシュさせるため、リフレッシュトークンのみを渡す
+	tokenSource := provider.OAuth2Config.TokenSource(provider.clientAuthContext(ctx), &oauth2.Token{RefreshToken: oldToken.RefreshToken})
+	newToken, err := tokenSource.Token()
+	if err != nil {
+		return err
+	}
+
+	if provider.Type == ProviderTypeOAuth2 {
+		profile, err := fetchProfile(ctx, provider, newToken)
+		if err != nil {
+			return err
+		}
+		if err := checkSessionUnchanged(id, oldToken); err != nil {
+			return err
+		}
+		session.SetProfile(id, profile)
+		session.SetToken(id, newToken)
+		session.SetProviderID(id, providerID)
+		session.SetExpiry(id, getTokenExpiry(newToken, nil))
+		return nil
+	}
+
+	oldIDToken, err := session.GetIDToken(id)
+	if err != nil {
+		return err
+	}
+
+	// リフレッシュのレスポンスにIDトークンを含めるかはIdP次第なので、含まれない場合は古いものを使い続ける
+	var newIDToken *oidc.IDToken
+	rawIDToken, hasIDToken := newToken.Extra("id_token").(string)
+	if hasIDToken {
+		newIDToken, err = provider.Verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			return err
+		}
+		// OIDCの仕様により、リフレッシュで得たIDトークンのissとsubは元のものと一致しなければならない
+		if newIDToken.Issuer != oldIDToken.Issuer || newIDToken.Subject != oldIDToken.Subject {
+			return errors.New("error: refreshed ID token does not belong to the same user")
+		}
+	}
+
+	userInfo, err := provider.OIDCProvider.UserInfo(ctx, oauth2.StaticTokenSource(newToken))
+	if err != nil {
+		return err
+	}
+	if err := checkSessionUnchanged(id, oldToken); err != nil {
+		return err
+	}
+
+	if newIDToken != nil {
+		session.SetIDToken(id, newIDToken)
+		session.SetRawIDToken(id, rawIDToken)
+	} else {
+		session.SetIDToken(id, oldIDToken)
+	}
+	session.SetUserInfo(id, userInfo)
+	session.SetToken(id, newToken)
+	session.SetProviderID(id, providerID)
+	session.SetExpiry(id, getTokenExpiry(newToken, newIDToken))
+	return nil
+}
+
+// IdPとの通信の間に、ロックを取らない処理(再ログインによる古いセッションの破棄や期限切れ)でセッションが無くなっていれば、書き戻さない
+func checkSessionUnchanged(id sessionid.ID, oldToken *oauth2.Token) error {
+	token, err := session.GetToken(id)
+	if err != nil || token.RefreshToken != oldToken.RefreshToken {
+		return errors.New("error: session was ended during refresh")
+	}
+	return nil
+}
+
+// IDトークンとアクセストークンの有効期限のうち、早い方を返す
+// idTokenがnilの場合はアクセストークンの有効期限のみを考慮する
+func getTokenExpiry(token *oauth2.Token, idToken *oidc.IDToken) time.Time {
+	expiry := token.Expiry
+	if idToken != nil && !idToken.Expiry.IsZero() && (expiry.IsZero() || idToken.Expiry.Before(expiry)) {
+		expiry = idToken.Expiry
+	}
+	return expiry
+}
+
+// Discoveryが終わっていないプロバイダーは見つからなかったものとして扱う
+func findProvider(config Config, providerID string) (*Provider, bool) {
+	for _, provider := range config.providers {
+		if provider.ID == providerID && provider.isReady() {
+			return provider, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,69 @@
+package oidc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+	"golang.org/x/oauth2"
+)
+
+func TestCheckSessionUnchanged(t *testing.T) {
+	session.Init()
+	oldToken := &oauth2.Token{RefreshToken: "old"}
+
+	tests := []struct {
+		name    string
+		current *oauth2.Token
+		wantErr bool
+	}{
+		{"same refresh token", &oauth2.Token{AccessToken: "a", RefreshToken: "old"}, false},
+		{"refresh token was replaced", &oauth2.Token{RefreshToken: "new"}, true},
+		{"session was ended", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := sessionid.ID("session-" + tt.name)
+			if tt.current != nil {
+				session.SetToken(id, tt.current)
+			}
+			err := checkSessionUnchanged(id, oldToken)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkSessionUnchanged() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLogoutExclusivelyWaitsForRefresh(t *testing.T) {
+	session.Init()
+	id := sessionid.ID("session")
+	session.SetToken(id, &oauth2.Token{RefreshToken: "refresh"})
+
+	lock := lockRefresh(id)
+	done := make(chan struct{})
+	go func() {
+		logoutExclusively(id)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("logoutExclusively() did not wait for the refresh lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := session.GetToken(id); err != nil {
+		t.Fatal("session was ended while the refresh lock was held")
+	}
+
+	unlockRefresh(id, lock)
+	<-done
+	if _, err := session.GetToken(id); err == nil {
+		t.Error("session was not ended after the refresh lock was released")
+	}
+	if _, found := refreshLocks[id]; found {
+		t.Error("refresh lock was not deleted")
+	}
+}
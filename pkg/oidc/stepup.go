@@ -0,0 +1,39 @@
+package oidc
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/bearer"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+)
+
+// ログイン中のユーザーを、ログインしたプロバイダーの認証フローに再び送り、より強い認証を求める
+// Upstreamの要求はidentity.RequirementKeyでContextに格納されている必要がある
+func NewStepUpHandler(config Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+
+		// ベアラートークンはリダイレクトで取り直せないため、クライアントに取り直しを求める
+		if _, ok := r.Context().Value(bearer.Key{}).(bearer.Token); ok {
+			logger.Info().Msg("Bearer token did not satisfy the requirement of upstream")
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_user_authentication"`)
+			i18n.Error(w, r, "error.insufficientAuthentication", http.StatusUnauthorized)
+			return
+		}
+
+		ident := r.Context().Value(identity.Key{}).(*identity.Identity)
+		provider, found := findProvider(config, ident.ProviderID)
+		if !found || provider.Type != ProviderTypeOIDC {
+			// OAuth2のプロバイダーはacrやauth_timeを返さないため、Step-upできない
+			logger.Warn().Str("providerID", ident.ProviderID).Msg("Provider of the session cannot perform step-up authentication")
+			renderErrorPage(w, r, http.StatusForbidden, i18n.Message(r, "error.stepUpUnsupported", ident.ProviderID))
+			return
+		}
+
+		logger.Info().Str("providerID", provider.ID).Msg("Starting step-up authentication")
+		createOIDCStartHandler(provider).ServeHTTP(w, r)
+	})
+}
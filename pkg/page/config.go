@@ -0,0 +1,7 @@
+package page
+
+type Config struct {
+	TemplateDir string
+	StaticDir   string
+	AppTitle    string
+}
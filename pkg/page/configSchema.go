@@ -0,0 +1,60 @@
+package page
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type ConfigSchema struct {
+	// ログインページ、エラーページ、ログアウトページのテンプレートを置くディレクトリ
+	// ファイル名が同じテンプレートのみを上書きし、無いものは組み込みのテンプレートを使う
+	TemplateDir string `json:"templateDir"`
+
+	// /oauth2/static以下で配信する、画像やCSSなどのファイルを置くディレクトリ
+	StaticDir string `json:"staticDir"`
+
+	// 各ページのタイトルなどに表示するアプリケーションの名前
+	AppTitle string `json:"appTitle"`
+}
+
+const defaultAppTitle string = "mini-oauth2-proxy"
+
+func (s *ConfigSchema) Validate() error {
+	errMessages := make([]string, 0)
+
+	if s.TemplateDir != "" {
+		if !isDirectory(s.TemplateDir) {
+			errMessages = append(errMessages, fmt.Sprintf("error: templateDir is not a directory: %s", s.TemplateDir))
+		} else if _, err := loadTemplates(s.TemplateDir); err != nil {
+			errMessages = append(errMessages, fmt.Sprintf("error: failed to load templates: %s", err.Error()))
+		}
+	}
+
+	if s.StaticDir != "" && !isDirectory(s.StaticDir) {
+		errMessages = append(errMessages, fmt.Sprintf("error: staticDir is not a directory: %s", s.StaticDir))
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func (s *ConfigSchema) CreateConfig() Config {
+	appTitle := s.AppTitle
+	if appTitle == "" {
+		appTitle = defaultAppTitle
+	}
+	return Config{
+		TemplateDir: s.TemplateDir,
+		StaticDir:   s.StaticDir,
+		AppTitle:    appTitle,
+	}
+}
@@ -0,0 +1,203 @@
+package page
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/redirect"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/requestid"
+)
+
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+const (
+	loginPage     string = "login_page.html"
+	errorPage     string = "error_page.html"
+	signedOutPage string = "signed_out_page.html"
+)
+
+const staticPath string = "/oauth2/static"
+
+var config Config
+
+// リクエストのたびに読み込まないよう、起動時に一度だけ読み込む
+var templates *template.Template
+
+func Init(c Config) {
+	config = c
+	t, err := loadTemplates(c.TemplateDir)
+	if err != nil {
+		panic(err)
+	}
+	templates = t
+}
+
+// 組み込みのテンプレートを読み込んだ後、テンプレートのディレクトリにある同名のファイルで上書きする
+func loadTemplates(dir string) (*template.Template, error) {
+	tmpl, err := template.ParseFS(embeddedTemplates, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return tmpl, nil
+	}
+	for _, name := range []string{loginPage, errorPage, signedOutPage} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if _, err := tmpl.ParseFiles(path); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// staticDirが指定されている場合のみ、静的ファイルを配信する
+func AddStaticEndpoint(r *chi.Mux) {
+	if config.StaticDir == "" {
+		return
+	}
+	r.Handle(staticPath+"/*", http.StripPrefix(staticPath, http.FileServer(noDirectoryFileSystem{http.Dir(config.StaticDir)})))
+}
+
+// http.FileServerはディレクトリへのリクエストにファイルの一覧を返すため、ディレクトリは存在しないものとして扱い404を返す
+type noDirectoryFileSystem struct {
+	fs http.FileSystem
+}
+
+func (n noDirectoryFileSystem) Open(name string) (http.File, error) {
+	f, err := n.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
+
+// 全てのページのテンプレートに渡す情報
+type Common struct {
+	AppTitle string
+
+	// Accept-Languageから決めた言語。テンプレートでは.Tでこの言語のメッセージを取得する
+	Language string
+
+	// ログイン後に戻る予定のパス。無い場合はこのページ自体のパス
+	RequestPath string
+
+	// 問い合わせの際にログと突き合わせられるように表示する
+	RequestID string
+}
+
+// テンプレートから{{.T "login.title"}}のようにメッセージカタログを参照するために使う
+func (c Common) T(key string, args ...any) string {
+	return i18n.Translate(c.Language, key, args...)
+}
+
+func newCommon(r *http.Request) Common {
+	common := Common{
+		AppTitle:    config.AppTitle,
+		Language:    i18n.Language(r),
+		RequestPath: r.URL.Path,
+	}
+	if redirectURL, ok := r.Context().Value(redirect.Key{}).(string); ok && redirectURL != "" {
+		if u, err := url.Parse(redirectURL); err == nil {
+			common.RequestPath = u.Path
+		}
+	}
+	if id, ok := r.Context().Value(requestid.Key{}).(requestid.ID); ok {
+		common.RequestID = string(id)
+	}
+	return common
+}
+
+type LoginData struct {
+	Common
+	Providers []LoginProvider
+
+	// Home Realm Discoveryを使わない場合は空文字列
+	HomeRealmDiscoveryURL string
+	RedirectURL           string
+
+	// Home Realm Discoveryでプロバイダーが見つからなかった場合に表示する
+	Message string
+	Email   string
+}
+
+type LoginProvider struct {
+	ID          string
+	DisplayName string
+
+	// 空文字列の場合はアイコンを表示しない
+	IconURL string
+
+	LoginURL    string
+	RedirectURL string
+}
+
+type ErrorData struct {
+	Common
+	Title   string
+	Message string
+
+	// 空文字列の場合は、やり直すためのリンクを表示しない
+	RetryURL string
+
+	// ログイン中のユーザーを表す文字列。空文字列の場合は表示しない
+	SignedInAs string
+	// 空文字列の場合は、ログアウトするためのリンクを表示しない
+	SignOutURL string
+}
+
+type SignedOutData struct {
+	Common
+}
+
+// フォワード認証では未ログインを401で伝える必要があるため、ステータスコードを指定できる
+func RenderLogin(w http.ResponseWriter, r *http.Request, status int, data LoginData) {
+	data.Common = newCommon(r)
+	render(w, r, status, loginPage, data)
+}
+
+// Titleが空文字列の場合は、リクエストの言語でのステータスコードの説明をタイトルにする
+func RenderError(w http.ResponseWriter, r *http.Request, status int, data ErrorData) {
+	data.Common = newCommon(r)
+	if data.Title == "" {
+		data.Title = i18n.StatusText(data.Language, status)
+	}
+	render(w, r, status, errorPage, data)
+}
+
+func RenderSignedOut(w http.ResponseWriter, r *http.Request) {
+	data := SignedOutData{Common: newCommon(r)}
+	render(w, r, http.StatusOK, signedOutPage, data)
+}
+
+func render(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	// ヘッダーを送った後なので、失敗してもステータスコードは変えられない
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		logger.Error().Err(err).Str("template", name).Msg("Failed to execute page template")
+	}
+}
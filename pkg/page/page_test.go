@@ -0,0 +1,44 @@
+package page
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestStaticEndpointDoesNotListDirectories(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "images"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "images", "logo.svg"), []byte("<svg></svg>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config = Config{StaticDir: dir}
+	r := chi.NewRouter()
+	AddStaticEndpoint(r)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/oauth2/static/images/logo.svg", http.StatusOK},
+		{"/oauth2/static/", http.StatusNotFound},
+		{"/oauth2/static/images/", http.StatusNotFound},
+		{"/oauth2/static/images", http.StatusNotFound},
+		{"/oauth2/static/missing.css", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.want)
+			}
+		})
+	}
+}
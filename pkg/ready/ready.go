@@ -10,16 +10,28 @@ import (
 
 const path string = "/oauth2/ready"
 
-func AddEndpoint(r *chi.Mux) {
-	r.HandleFunc(path, handler)
+// リクエストを処理する準備ができていない場合は、その理由をエラーとして返す
+type Check func() error
+
+func AddEndpoint(r *chi.Mux, checks ...Check) {
+	r.HandleFunc(path, createHandler(checks))
 }
 
-func handler(w http.ResponseWriter, r *http.Request) {
-	logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
-	logger.Debug().Msg("Returned ready response OK")
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write([]byte("OK"))
-	if err != nil {
-		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+func createHandler(checks []Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		for _, check := range checks {
+			if err := check(); err != nil {
+				logger.Debug().Err(err).Msg("Returned ready response NOT READY")
+				http.Error(w, "NOT READY", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		logger.Debug().Msg("Returned ready response OK")
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte("OK"))
+		if err != nil {
+			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		}
 	}
 }
@@ -0,0 +1,7 @@
+package redirect
+
+type Config struct {
+	// 小文字で保持する
+	AllowedHosts   []string
+	AllowedDomains []string
+}
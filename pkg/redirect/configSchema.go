@@ -0,0 +1,51 @@
+package redirect
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type ConfigSchema struct {
+	// ログイン後のリダイレクト先として許可するホスト。"app.example.com:8443"のようにポートも含めて完全一致で比較する
+	// プロキシ自体のホストは常に許可される
+	AllowedHosts []string `json:"allowedHosts"`
+
+	// ログイン後のリダイレクト先として許可するドメイン。"example.com"は、example.comとそのサブドメインの全てを許可する
+	AllowedDomains []string `json:"allowedDomains"`
+}
+
+func (s *ConfigSchema) Validate() error {
+	errMessages := make([]string, 0)
+
+	for _, host := range s.AllowedHosts {
+		if host == "" || strings.Contains(host, "/") {
+			errMessages = append(errMessages, fmt.Sprintf("error: redirect allowedHosts has invalid host: %s", host))
+		}
+	}
+	for _, domain := range s.AllowedDomains {
+		if domain == "" || strings.ContainsAny(domain, "/:") || strings.HasPrefix(domain, ".") {
+			errMessages = append(errMessages, fmt.Sprintf("error: redirect allowedDomains has invalid domain: %s", domain))
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
+func (s *ConfigSchema) CreateConfig() Config {
+	hosts := make([]string, 0)
+	for _, host := range s.AllowedHosts {
+		hosts = append(hosts, strings.ToLower(host))
+	}
+	domains := make([]string, 0)
+	for _, domain := range s.AllowedDomains {
+		domains = append(domains, strings.ToLower(domain))
+	}
+	return Config{
+		AllowedHosts:   hosts,
+		AllowedDomains: domains,
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
@@ -12,6 +14,12 @@ import (
 
 type Key struct{}
 
+var config Config
+
+func Init(c Config) {
+	config = c
+}
+
 // 指定されたリクエストから考えられる認証成功後のリダイレクトURLを探索する
 // 明示的に指定されていないものでも、リクエスト情報をもとに決定するため、Upstreamへのリクエストにしか使用できない
 func FindMiddleware(next http.Handler) http.Handler {
@@ -45,6 +53,19 @@ func GetMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// フォワード認証では、リクエストはリバースプロキシから送られてくるため、元のリクエストのURLをヘッダーから復元する
+// 明示的に指定されたリダイレクトURLがあれば、そちらを優先する
+func ForwardedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		logger.Debug().Msg("Finding forwarded application redirect URL.")
+		url := getForwardedRedirectURL(r)
+		*logger = logger.With().Str("forwarded redirect URL", url).Logger()
+		ctx := context.WithValue(r.Context(), Key{}, url)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func getExplicitRedirectURL(r *http.Request) string {
 	if canRedirectByQueryParam(r.URL.Query()) {
 		return getURLFromQueryParam(r.URL.Query())
@@ -62,25 +83,83 @@ func getRedirectURL(r *http.Request) string {
 	return proxyURL.GetURLFromPath(r.URL.Path).String()
 }
 
+// nginxのauth_requestなどの設定例ではrdが使われるため、redirectに加えてrdも受け付ける
 func canRedirectByQueryParam(params url.Values) bool {
-	redirectURL := params.Get("redirect")
-	return isValidURL(redirectURL)
+	return IsValidURL(params.Get("redirect")) || IsValidURL(params.Get("rd"))
 }
 
 func canRedirectByHeader(header http.Header) bool {
 	redirectURL := header.Get("X-Auth-Request-Redirect")
-	return isValidURL(redirectURL)
+	return IsValidURL(redirectURL)
 }
 
 func getURLFromQueryParam(params url.Values) string {
-	return params.Get("redirect")
+	if redirectURL := params.Get("redirect"); IsValidURL(redirectURL) {
+		return redirectURL
+	}
+	return params.Get("rd")
+}
+
+// nginxはX-Original-URLに元のURL全体を、TraefikとCaddyはX-Forwarded-Proto、X-Forwarded-HostおよびX-Forwarded-Uriに分けて送る
+// X-Original-URLにパスのみを送るリバースプロキシもあるため、その場合はX-Forwarded-Uriと同様に扱う
+func getForwardedRedirectURL(r *http.Request) string {
+	if explicitURL := getExplicitRedirectURL(r); explicitURL != "" {
+		return explicitURL
+	}
+
+	originalURL := r.Header.Get("X-Original-URL")
+	if IsValidURL(originalURL) {
+		return originalURL
+	}
+
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = originalURL
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if uri == "" || host == "" {
+		return ""
+	}
+	u, err := url.Parse(uri)
+	if err != nil || u.IsAbs() {
+		return ""
+	}
+	u.Scheme = r.Header.Get("X-Forwarded-Proto")
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+	u.Host = host
+	if !IsValidURL(u.String()) {
+		return ""
+	}
+	return u.String()
 }
 
 func getURLFromHeader(header http.Header) string {
 	return header.Get("X-Auth-Request-Redirect")
 }
 
-func isValidURL(toTest string) bool {
+// ログイン後にリダイレクトするURLは、クエリパラメーターやヘッダーで誰でも指定できるため、
+// オープンリダイレクトにならないよう、プロキシ自体と許可されたホストへのhttpまたはhttpsのURLのみを受け付ける
+// このパッケージのミドルウェアを通さずにリダイレクトURLを組み立てる場合も、これで検証する
+func IsValidURL(toTest string) bool {
 	u, err := url.Parse(toTest)
-	return err == nil && u.Scheme != "" && u.Host != ""
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return false
+	}
+	return isAllowedHost(u)
+}
+
+func isAllowedHost(u *url.URL) bool {
+	host := strings.ToLower(u.Host)
+	if host == strings.ToLower(proxyURL.GetURLFromPath("/").Host) || slices.Contains(config.AllowedHosts, host) {
+		return true
+	}
+	hostname := strings.ToLower(u.Hostname())
+	for _, domain := range config.AllowedDomains {
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+	return false
 }
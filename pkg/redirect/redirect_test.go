@@ -0,0 +1,171 @@
+package redirect
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/proxyURL"
+)
+
+func setup() {
+	proxyURL.Init(proxyURL.Config{Host: "proxy.example.com"})
+	Init(Config{
+		AllowedHosts:   []string{"app.example.com", "admin.example.com:8443"},
+		AllowedDomains: []string{"example.org"},
+	})
+}
+
+func TestIsValidURL(t *testing.T) {
+	setup()
+
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{"proxy host", "https://proxy.example.com/path", true},
+		{"allowed host", "https://app.example.com/path?x=1", true},
+		{"allowed host is case insensitive", "https://APP.example.com/", true},
+		{"allowed host with port", "https://admin.example.com:8443/", true},
+		{"allowed host with other port", "https://admin.example.com/", false},
+		{"allowed host with unexpected port", "https://app.example.com:8443/", false},
+		{"allowed domain", "https://example.org/", true},
+		{"subdomain of allowed domain", "https://a.b.example.org/", true},
+		{"allowed domain with port", "http://example.org:8080/", true},
+		{"suffix without dot", "https://evilexample.org/", false},
+		{"allowed domain as prefix", "https://example.org.evil.com/", false},
+		{"other host", "https://evil.com/", false},
+		{"userinfo trick", "https://app.example.com@evil.com/", false},
+		{"javascript scheme", "javascript://app.example.com/%0aalert(1)", false},
+		{"protocol relative", "//evil.com/", false},
+		{"relative path", "/path", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsValidURL(tt.url); got != tt.want {
+				t.Errorf("IsValidURL(%q) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetForwardedRedirectURL(t *testing.T) {
+	setup()
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "allowed rd",
+			target: "/oauth2/sign_in?rd=https%3A%2F%2Fapp.example.com%2Fx",
+			want:   "https://app.example.com/x",
+		},
+		{
+			name:   "disallowed rd",
+			target: "/oauth2/sign_in?rd=https%3A%2F%2Fevil.com%2F",
+			want:   "",
+		},
+		{
+			name:   "disallowed redirect falls back to allowed rd",
+			target: "/oauth2/sign_in?redirect=https%3A%2F%2Fevil.com%2F&rd=https%3A%2F%2Fapp.example.com%2F",
+			want:   "https://app.example.com/",
+		},
+		{
+			name:    "disallowed X-Auth-Request-Redirect",
+			target:  "/oauth2/auth",
+			headers: map[string]string{"X-Auth-Request-Redirect": "https://evil.com/"},
+			want:    "",
+		},
+		{
+			name:    "allowed X-Original-URL",
+			target:  "/oauth2/auth",
+			headers: map[string]string{"X-Original-URL": "https://app.example.com/x"},
+			want:    "https://app.example.com/x",
+		},
+		{
+			name:    "disallowed X-Original-URL",
+			target:  "/oauth2/auth",
+			headers: map[string]string{"X-Original-URL": "https://evil.com/x"},
+			want:    "",
+		},
+		{
+			name:   "allowed X-Forwarded-Host",
+			target: "/oauth2/auth",
+			headers: map[string]string{
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "a.example.org",
+				"X-Forwarded-Uri":   "/x?y=1",
+			},
+			want: "https://a.example.org/x?y=1",
+		},
+		{
+			name:   "disallowed X-Forwarded-Host",
+			target: "/oauth2/auth",
+			headers: map[string]string{
+				"X-Forwarded-Host": "evil.com",
+				"X-Forwarded-Uri":  "/x",
+			},
+			want: "",
+		},
+		{
+			name:   "disallowed X-Forwarded-Proto",
+			target: "/oauth2/auth",
+			headers: map[string]string{
+				"X-Forwarded-Proto": "javascript",
+				"X-Forwarded-Host":  "app.example.com",
+				"X-Forwarded-Uri":   "/x",
+			},
+			want: "",
+		},
+		{
+			name:   "path only X-Original-URL",
+			target: "/oauth2/auth",
+			headers: map[string]string{
+				"X-Forwarded-Host": "app.example.com",
+				"X-Original-URL":   "/x",
+			},
+			want: "https://app.example.com/x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if got := getForwardedRedirectURL(r); got != tt.want {
+				t.Errorf("getForwardedRedirectURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  ConfigSchema
+		wantErr bool
+	}{
+		{"empty", ConfigSchema{}, false},
+		{"valid", ConfigSchema{AllowedHosts: []string{"app.example.com:8443"}, AllowedDomains: []string{"example.org"}}, false},
+		{"host with scheme", ConfigSchema{AllowedHosts: []string{"https://app.example.com"}}, true},
+		{"empty host", ConfigSchema{AllowedHosts: []string{""}}, true},
+		{"domain with leading dot", ConfigSchema{AllowedDomains: []string{".example.org"}}, true},
+		{"domain with port", ConfigSchema{AllowedDomains: []string{"example.org:443"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.schema.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
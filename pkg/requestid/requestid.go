@@ -6,6 +6,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/crypto"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
 )
 
@@ -29,7 +30,7 @@ func AddIDMiddleware(next http.Handler) http.Handler {
 		id, err := newID()
 		if err != nil {
 			logger.Error().Err(err).Msg("Failed to create request ID")
-			http.Error(w, "error: Failed to create request ID", http.StatusInternalServerError)
+			i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
 			return
 		}
 
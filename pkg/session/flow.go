@@ -0,0 +1,145 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+)
+
+// 実行中の認証フローの情報
+// 複数のタブで同時にログインを始めても互いに上書きしないよう、セッションごとではなくstateごとに保持する
+type Flow struct {
+	State     string
+	SessionID sessionid.ID
+
+	// フローを開始したブラウザのflow_bindingのCookieの値
+	Binding string
+
+	// フローを開始したプロバイダー。別のプロバイダーのコールバックで使われないように確認する
+	ProviderID string
+
+	Nonce string
+
+	// PKCEを使わない場合は空文字列
+	CodeVerifier string
+
+	RedirectURL string
+
+	// Step-upでない場合はゼロ値
+	Requirement identity.Requirement
+}
+
+// 1つのセッションが同時に実行できる認証フローの上限
+// これを超えた場合は最も古いフローを破棄する
+const maxFlowsPerSession int = 5
+
+// セッションごとのフローの一覧の読み書きを排他する
+var flowLock sync.Mutex
+
+// stateはセッションIDと異なる値であり、セッションIDとの衝突を避けるため区切り文字を入れる
+func getFlowKey(state string) string {
+	return "flow:" + state
+}
+func getFlowStatesKey(id sessionid.ID) string {
+	return string(id + "flowStates")
+}
+
+// フローはそれぞれtemporaryExpireTimeで期限切れになる
+func AddFlow(flow Flow) error {
+	flowLock.Lock()
+	defer flowLock.Unlock()
+
+	states := getLiveFlowStates(flow.SessionID)
+	for len(states) >= maxFlowsPerSession {
+		dataStore.Delete(getFlowKey(states[0]))
+		states = states[1:]
+	}
+
+	if err := dataStore.Add(getFlowKey(flow.State), flow, temporaryExpireTime); err != nil {
+		return err
+	}
+	states = append(states, flow.State)
+	dataStore.Set(getFlowStatesKey(flow.SessionID), states, temporaryExpireTime)
+	return nil
+}
+
+func GetFlow(state string) (Flow, error) {
+	flow, found := dataStore.Get(getFlowKey(state))
+	if !found {
+		return Flow{}, errors.New("error: flow of the state not found")
+	}
+	return flow.(Flow), nil
+}
+
+// stateは一度しか使えないため、コールバックの処理が終わったら必ず削除する
+func DeleteFlow(state string) {
+	flowLock.Lock()
+	defer flowLock.Unlock()
+
+	value, found := dataStore.Get(getFlowKey(state))
+	if !found {
+		return
+	}
+	flow := value.(Flow)
+	dataStore.Delete(getFlowKey(state))
+
+	states := getLiveFlowStates(flow.SessionID)
+	if len(states) == 0 {
+		dataStore.Delete(getFlowStatesKey(flow.SessionID))
+		return
+	}
+	dataStore.Set(getFlowStatesKey(flow.SessionID), states, temporaryExpireTime)
+}
+
+// セッションが実行中の全てのフローを削除する
+func DeleteFlows(id sessionid.ID) {
+	flowLock.Lock()
+	defer flowLock.Unlock()
+
+	for _, state := range getLiveFlowStates(id) {
+		dataStore.Delete(getFlowKey(state))
+	}
+	dataStore.Delete(getFlowStatesKey(id))
+}
+
+// 期限切れや削除済みのものを除いた、セッションのフローのstateを古い順に返す
+// flowLockを取得してから呼ぶ必要がある
+func getLiveFlowStates(id sessionid.ID) []string {
+	states := make([]string, 0)
+	value, found := dataStore.Get(getFlowStatesKey(id))
+	if !found {
+		return states
+	}
+	for _, state := range value.([]string) {
+		if _, found := dataStore.Get(getFlowKey(state)); found {
+			states = append(states, state)
+		}
+	}
+	return states
+}
+
+// セッションIDの再発行後も、他のタブで実行中のフローを完了できるように引き継ぐ
+func moveFlows(oldID, newID sessionid.ID) {
+	flowLock.Lock()
+	defer flowLock.Unlock()
+
+	states := make([]string, 0)
+	for _, state := range getLiveFlowStates(oldID) {
+		value, expiration, found := dataStore.GetWithExpiration(getFlowKey(state))
+		remaining := time.Until(expiration)
+		if !found || remaining <= 0 {
+			continue
+		}
+		flow := value.(Flow)
+		flow.SessionID = newID
+		dataStore.Set(getFlowKey(state), flow, remaining)
+		states = append(states, state)
+	}
+	dataStore.Delete(getFlowStatesKey(oldID))
+	if len(states) > 0 {
+		dataStore.Set(getFlowStatesKey(newID), states, temporaryExpireTime)
+	}
+}
@@ -0,0 +1,120 @@
+package session
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+)
+
+// Back-Channel Logoutなど、IdP側のユーザーやセッションからプロキシのセッションを逆引きするための索引
+// IDTokenの保存と削除に連動して更新される
+type sessionIndex struct {
+	mu        sync.Mutex
+	bySubject map[string]map[sessionid.ID]struct{}
+	bySID     map[string]map[sessionid.ID]struct{}
+
+	// 索引から削除するときのために、セッションごとに登録したキーを覚えておく
+	entries map[sessionid.ID]indexEntry
+}
+
+type indexEntry struct {
+	subjectKey string
+	sidKey     string
+}
+
+var index *sessionIndex
+
+func initIndex() {
+	index = &sessionIndex{
+		bySubject: make(map[string]map[sessionid.ID]struct{}),
+		bySID:     make(map[string]map[sessionid.ID]struct{}),
+		entries:   make(map[sessionid.ID]indexEntry),
+	}
+	// 明示的な削除と有効期限切れのどちらでも索引から取り除けるように、キャッシュからの削除を監視する
+	dataStore.OnEvicted(func(key string, value any) {
+		if _, ok := value.(*oidc.IDToken); ok && strings.HasSuffix(key, "IDToken") {
+			index.remove(sessionid.ID(strings.TrimSuffix(key, "IDToken")))
+		}
+	})
+}
+
+// subとsidはIdPごとに一意なので、issuerと組み合わせてキーにする
+func getIndexKey(issuer, value string) string {
+	return issuer + " " + value
+}
+
+func (i *sessionIndex) add(id sessionid.ID, idToken *oidc.IDToken) {
+	var sidClaim struct {
+		SID string `json:"sid"`
+	}
+	// sidはOPTIONALなクレームなので、取得できなくてもsubでの索引は作る
+	_ = idToken.Claims(&sidClaim)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.removeLocked(id)
+	entry := indexEntry{
+		subjectKey: getIndexKey(idToken.Issuer, idToken.Subject),
+	}
+	addToSet(i.bySubject, entry.subjectKey, id)
+	if sidClaim.SID != "" {
+		entry.sidKey = getIndexKey(idToken.Issuer, sidClaim.SID)
+		addToSet(i.bySID, entry.sidKey, id)
+	}
+	i.entries[id] = entry
+}
+
+func (i *sessionIndex) remove(id sessionid.ID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.removeLocked(id)
+}
+
+func (i *sessionIndex) removeLocked(id sessionid.ID) {
+	entry, found := i.entries[id]
+	if !found {
+		return
+	}
+	removeFromSet(i.bySubject, entry.subjectKey, id)
+	if entry.sidKey != "" {
+		removeFromSet(i.bySID, entry.sidKey, id)
+	}
+	delete(i.entries, id)
+}
+
+func (i *sessionIndex) find(set map[string]map[sessionid.ID]struct{}, key string) []sessionid.ID {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	ids := make([]sessionid.ID, 0, len(set[key]))
+	for id := range set[key] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func addToSet(set map[string]map[sessionid.ID]struct{}, key string, id sessionid.ID) {
+	if _, exists := set[key]; !exists {
+		set[key] = make(map[sessionid.ID]struct{})
+	}
+	set[key][id] = struct{}{}
+}
+
+func removeFromSet(set map[string]map[sessionid.ID]struct{}, key string, id sessionid.ID) {
+	delete(set[key], id)
+	if len(set[key]) == 0 {
+		delete(set, key)
+	}
+}
+
+// 指定されたIdPのユーザーがログインしている全てのセッションを返す
+func FindSessionsBySubject(issuer, subject string) []sessionid.ID {
+	return index.find(index.bySubject, getIndexKey(issuer, subject))
+}
+
+// 指定されたIdPのセッション(sid)に紐づく全てのセッションを返す
+func FindSessionsBySID(issuer, sid string) []sessionid.ID {
+	return index.find(index.bySID, getIndexKey(issuer, sid))
+}
@@ -0,0 +1,24 @@
+package session
+
+import (
+	"errors"
+	"time"
+)
+
+// ログアウトトークンの再送を拒否するため、使用済みのjtiをトークンの有効期限まで覚えておく
+// 有効期限を過ぎたトークンは署名の検証で拒否されるため、それ以上覚えておく必要はない
+func UseLogoutTokenID(issuer string, jti string, expiry time.Time) error {
+	ttl := time.Until(expiry)
+	if ttl <= 0 {
+		return errors.New("error: logout token is expired")
+	}
+	// Addは同じキーが既にあれば失敗するため、確認と登録の間に別のリクエストが割り込むことはない
+	if err := dataStore.Add(getLogoutTokenIDKey(issuer, jti), true, ttl); err != nil {
+		return errors.New("error: logout token was already used")
+	}
+	return nil
+}
+
+func getLogoutTokenIDKey(issuer string, jti string) string {
+	return "logoutTokenID:" + issuer + " " + jti
+}
@@ -6,77 +6,99 @@ import (
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	cache "github.com/patrickmn/go-cache"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+	"golang.org/x/oauth2"
 )
 
-// nonceおよびstateは、OIDCのフローの実行中だけ保持しておけば良いため、短い
+// nonce、stateおよびPKCEのcode verifierなどのフローの情報は、OIDCのフローの実行中だけ保持しておけば良いため、短い
 const temporaryExpireTime time.Duration = 3 * time.Minute
 
-// IDTokenおよびUserInfoは、ログインしている間は保持し続ける必要があるため、長い
+// IDToken、UserInfoおよびトークンは、ログインしている間は保持し続ける必要があるため、長い
+// リフレッシュトークンを持つセッションは、トークンを更新するたびにこの期間だけ延長される
 const sessionExpireTime time.Duration = 1 * time.Hour
 
+// セッションの残り時間がこれを下回ったら延長する
+// リクエストのたびに延長するとCookieを毎回発行し直すことになるため、残り時間が半分を切ったときのみ延長する
+const sessionExtendThreshold time.Duration = sessionExpireTime / 2
+
 // 5分でExpireするデフォルト設定は、シグネチャが要求するため設定しているが、実際は使っていない
 // 手動でキャッシュ時間を設定している
 var dataStore *cache.Cache
 
 func Init() {
 	dataStore = cache.New(5*time.Minute, 5*time.Minute)
+	initIndex()
 }
 
-func getNonceKey(id sessionid.ID) string {
-	return string(id + "nonce")
-}
-func getStateKey(id sessionid.ID) string {
-	return string(id + "state")
-}
-func getRedirectURLKey(id sessionid.ID) string {
-	return string(id + "redirectURL")
-}
 func getIDTokenKey(id sessionid.ID) string {
 	return string(id + "IDToken")
 }
 func getUserInfoKey(id sessionid.ID) string {
 	return string(id + "UserInfo")
 }
-
-func SetNonce(id sessionid.ID, nonce string) error {
-	key := getNonceKey(id)
-	return dataStore.Add(key, nonce, temporaryExpireTime)
+func getRawIDTokenKey(id sessionid.ID) string {
+	return string(id + "rawIDToken")
 }
-
-func SetState(id sessionid.ID, state string) error {
-	key := getStateKey(id)
-	return dataStore.Add(key, state, temporaryExpireTime)
+func getProfileKey(id sessionid.ID) string {
+	return string(id + "profile")
 }
-
-func SetRedirectURL(id sessionid.ID, redirectURL string) error {
-	key := getRedirectURLKey(id)
-	return dataStore.Add(key, redirectURL, temporaryExpireTime)
+func getTokenKey(id sessionid.ID) string {
+	return string(id + "token")
+}
+func getProviderIDKey(id sessionid.ID) string {
+	return string(id + "providerID")
+}
+func getExpiryKey(id sessionid.ID) string {
+	return string(id + "expiry")
 }
 
+// ログイン中の情報はトークンのリフレッシュ時に上書きするため、AddではなくSetを使う
+
 func SetIDToken(id sessionid.ID, idToken *oidc.IDToken) error {
 	key := getIDTokenKey(id)
-	return dataStore.Add(key, idToken, sessionExpireTime)
+	dataStore.Set(key, idToken, sessionExpireTime)
+	index.add(id, idToken)
+	return nil
 }
 
 func SetUserInfo(id sessionid.ID, userInfo *oidc.UserInfo) error {
 	key := getUserInfoKey(id)
-	return dataStore.Add(key, userInfo, sessionExpireTime)
+	dataStore.Set(key, userInfo, sessionExpireTime)
+	return nil
 }
 
-func DeleteNonce(id sessionid.ID) {
-	key := getNonceKey(id)
-	dataStore.Delete(key)
+// ログアウト時のid_token_hintとして使うため、検証前の文字列のIDトークンも保持する
+func SetRawIDToken(id sessionid.ID, rawIDToken string) error {
+	key := getRawIDTokenKey(id)
+	dataStore.Set(key, rawIDToken, sessionExpireTime)
+	return nil
 }
 
-func DeleteState(id sessionid.ID) {
-	key := getNonceKey(id)
-	dataStore.Delete(key)
+// OAuth2のプロバイダーでログインしたセッションは、IDTokenとUserInfoの代わりにプロフィールを持つ
+func SetProfile(id sessionid.ID, profile identity.JSONClaims) error {
+	key := getProfileKey(id)
+	dataStore.Set(key, profile, sessionExpireTime)
+	return nil
 }
 
-func DeleteRedirectURL(id sessionid.ID) {
-	key := getRedirectURLKey(id)
-	dataStore.Delete(key)
+func SetToken(id sessionid.ID, token *oauth2.Token) error {
+	key := getTokenKey(id)
+	dataStore.Set(key, token, sessionExpireTime)
+	return nil
+}
+
+func SetProviderID(id sessionid.ID, providerID string) error {
+	key := getProviderIDKey(id)
+	dataStore.Set(key, providerID, sessionExpireTime)
+	return nil
+}
+
+// expiryはIDトークンまたはアクセストークンの有効期限のうち早い方であり、この時刻までにリフレッシュする必要がある
+func SetExpiry(id sessionid.ID, expiry time.Time) error {
+	key := getExpiryKey(id)
+	dataStore.Set(key, expiry, sessionExpireTime)
+	return nil
 }
 
 func DeleteIDToken(id sessionid.ID) {
@@ -89,31 +111,29 @@ func DeleteUserInfo(id sessionid.ID) {
 	dataStore.Delete(key)
 }
 
-func GetNonce(id sessionid.ID) (string, error) {
-	key := getNonceKey(id)
-	nonce, found := dataStore.Get(key)
-	if !found {
-		return "", errors.New("error: nonce not found")
-	}
-	return nonce.(string), nil
+func DeleteRawIDToken(id sessionid.ID) {
+	key := getRawIDTokenKey(id)
+	dataStore.Delete(key)
 }
 
-func GetState(id sessionid.ID) (string, error) {
-	key := getStateKey(id)
-	state, found := dataStore.Get(key)
-	if !found {
-		return "", errors.New("error: state not found")
-	}
-	return state.(string), nil
+func DeleteProfile(id sessionid.ID) {
+	key := getProfileKey(id)
+	dataStore.Delete(key)
 }
 
-func GetRedirectURL(id sessionid.ID) (string, error) {
-	key := getRedirectURLKey(id)
-	redirectURL, found := dataStore.Get(key)
-	if !found {
-		return "", errors.New("error: redirect URL not found")
-	}
-	return redirectURL.(string), nil
+func DeleteToken(id sessionid.ID) {
+	key := getTokenKey(id)
+	dataStore.Delete(key)
+}
+
+func DeleteProviderID(id sessionid.ID) {
+	key := getProviderIDKey(id)
+	dataStore.Delete(key)
+}
+
+func DeleteExpiry(id sessionid.ID) {
+	key := getExpiryKey(id)
+	dataStore.Delete(key)
 }
 
 func GetIDToken(id sessionid.ID) (*oidc.IDToken, error) {
@@ -134,11 +154,101 @@ func GetUserInfo(id sessionid.ID) (*oidc.UserInfo, error) {
 	return userInfo.(*oidc.UserInfo), nil
 }
 
+func GetRawIDToken(id sessionid.ID) (string, error) {
+	key := getRawIDTokenKey(id)
+	rawIDToken, found := dataStore.Get(key)
+	if !found {
+		return "", errors.New("error: raw IDToken not found")
+	}
+	return rawIDToken.(string), nil
+}
+
+func GetProfile(id sessionid.ID) (identity.JSONClaims, error) {
+	key := getProfileKey(id)
+	profile, found := dataStore.Get(key)
+	if !found {
+		return nil, errors.New("error: profile not found")
+	}
+	return profile.(identity.JSONClaims), nil
+}
+
+func GetToken(id sessionid.ID) (*oauth2.Token, error) {
+	key := getTokenKey(id)
+	token, found := dataStore.Get(key)
+	if !found {
+		return nil, errors.New("error: token not found")
+	}
+	return token.(*oauth2.Token), nil
+}
+
+func GetProviderID(id sessionid.ID) (string, error) {
+	key := getProviderIDKey(id)
+	providerID, found := dataStore.Get(key)
+	if !found {
+		return "", errors.New("error: provider ID not found")
+	}
+	return providerID.(string), nil
+}
+
+func GetExpiry(id sessionid.ID) (time.Time, error) {
+	key := getExpiryKey(id)
+	expiry, found := dataStore.Get(key)
+	if !found {
+		return time.Time{}, errors.New("error: expiry not found")
+	}
+	return expiry.(time.Time), nil
+}
+
+// セッション自体の有効期限。延長やリフレッシュを行わなければ、この時刻にログアウトされる
+func GetSessionExpiry(id sessionid.ID) (time.Time, error) {
+	key := getTokenKey(id)
+	_, expiration, found := dataStore.GetWithExpiration(key)
+	if !found {
+		return time.Time{}, errors.New("error: session not found")
+	}
+	return expiration, nil
+}
+
+// セッション自体の有効期限が近づいているかを判定する
+// IDTokenを持たないOAuth2のセッションもあるため、全てのセッションが持つトークンの有効期限で判定する
+func NeedsExtend(id sessionid.ID) bool {
+	key := getTokenKey(id)
+	_, expiration, found := dataStore.GetWithExpiration(key)
+	if !found {
+		return false
+	}
+	return time.Until(expiration) < sessionExtendThreshold
+}
+
+// ログイン中の情報の有効期限をsessionExpireTimeだけ延長する
+func ExtendSession(id sessionid.ID) {
+	keys := []string{
+		getIDTokenKey(id),
+		getUserInfoKey(id),
+		getRawIDTokenKey(id),
+		getProfileKey(id),
+		getTokenKey(id),
+		getProviderIDKey(id),
+		getExpiryKey(id),
+	}
+	for _, key := range keys {
+		if value, found := dataStore.Get(key); found {
+			dataStore.Set(key, value, sessionExpireTime)
+		}
+	}
+}
+
 func RefreshSession(oldID, newID sessionid.ID) error {
+	moveFlows(oldID, newID)
 	defer func() {
 		// リフレッシュに失敗するような異常な事態では、最悪を避けるために安全側に倒す
 		DeleteIDToken(oldID)
 		DeleteUserInfo(oldID)
+		DeleteRawIDToken(oldID)
+		DeleteProfile(oldID)
+		DeleteToken(oldID)
+		DeleteProviderID(oldID)
+		DeleteExpiry(oldID)
 	}()
 
 	if idToken, err := GetIDToken(oldID); err == nil {
@@ -153,5 +263,35 @@ func RefreshSession(oldID, newID sessionid.ID) error {
 		}
 	}
 
+	if rawIDToken, err := GetRawIDToken(oldID); err == nil {
+		if setErr := SetRawIDToken(newID, rawIDToken); setErr != nil {
+			return setErr
+		}
+	}
+
+	if profile, err := GetProfile(oldID); err == nil {
+		if setErr := SetProfile(newID, profile); setErr != nil {
+			return setErr
+		}
+	}
+
+	if token, err := GetToken(oldID); err == nil {
+		if setErr := SetToken(newID, token); setErr != nil {
+			return setErr
+		}
+	}
+
+	if providerID, err := GetProviderID(oldID); err == nil {
+		if setErr := SetProviderID(newID, providerID); setErr != nil {
+			return setErr
+		}
+	}
+
+	if expiry, err := GetExpiry(oldID); err == nil {
+		if setErr := SetExpiry(newID, expiry); setErr != nil {
+			return setErr
+		}
+	}
+
 	return nil
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/crypto"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
 )
 
@@ -38,7 +39,7 @@ func LoadMiddleware(next http.Handler) http.Handler {
 			newCookie, newID, err := getRefreshedCookie()
 			if err != nil {
 				logger.Error().Err(err).Msg("Failed to refresh session")
-				http.Error(w, "error: Failed to refresh session", http.StatusInternalServerError)
+				i18n.Error(w, r, "error.sessionCreateFailed", http.StatusInternalServerError)
 				return
 			}
 
@@ -62,16 +63,19 @@ func getRefreshedCookie() (*http.Cookie, ID, error) {
 	if err != nil {
 		return nil, "", errors.New("error: Failed to create session ID")
 	}
-	cookie := &http.Cookie{
+	return newCookie(newID), newID, nil
+}
+
+func newCookie(id ID) *http.Cookie {
+	return &http.Cookie{
 		Name:     cookieName,
-		Value:    string(newID),
+		Value:    string(id),
 		Path:     "/",
 		Expires:  time.Now().Add(60 * time.Minute),
 		Secure:   true,
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 	}
-	return cookie, newID, nil
 }
 
 func RefreshSession(w http.ResponseWriter, r *http.Request) (ID, error) {
@@ -82,3 +86,23 @@ func RefreshSession(w http.ResponseWriter, r *http.Request) (ID, error) {
 	http.SetCookie(w, newCookie)
 	return newID, nil
 }
+
+// セッションIDはそのままに、Cookieの有効期限を延長する
+// トークンのリフレッシュによってセッションが延長されたときに使う
+func ExtendSession(w http.ResponseWriter, id ID) {
+	http.SetCookie(w, newCookie(id))
+}
+
+// ログアウト時に、ブラウザからセッションIDのCookieを削除させる
+func ExpireCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
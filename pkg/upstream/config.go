@@ -1,6 +1,10 @@
 package upstream
 
-import "net/url"
+import (
+	"net/url"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
+)
 
 type Config struct {
 	Servers []Server
@@ -11,4 +15,7 @@ type Server struct {
 	URL         *url.URL
 	MatchPrefix string
 	Timeout     *Duration
+	Requirement identity.Requirement
+	Allowlist   identity.Allowlist
+	Rules       []Rule
 }
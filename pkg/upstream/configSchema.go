@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
 )
 
 type ConfigSchema struct {
@@ -18,6 +20,48 @@ type ServerSchema struct {
 	URL         string    `json:"url"`
 	MatchPrefix string    `json:"matchPath"`
 	Timeout     *Duration `json:"timeout,omitempty"`
+
+	// 以下を指定すると、IDトークンが満たさないユーザーにはIdPでの再認証(Step-up)を求める
+	// 許可するacrの値
+	ACRValues []string `json:"acrValues"`
+	// 必須の認証方式(amr)
+	AMR []string `json:"amr"`
+	// 最後にIdPで認証してからの経過時間の上限
+	MaxAuthAge *Duration `json:"maxAuthAge,omitempty"`
+
+	// 以下を指定すると、満たさないユーザーには403を返す。メールアドレスとグループの両方を指定した場合は両方を満たす必要がある
+	// いずれかに完全一致するメールアドレスか、いずれかのドメインのメールアドレスを持つユーザーのみを許可する
+	AllowedEmails       []string `json:"allowedEmails"`
+	AllowedEmailDomains []string `json:"allowedEmailDomains"`
+	// trueの場合、email_verifiedがtrueでないメールアドレスも許可する
+	AllowUnverifiedEmail bool `json:"allowUnverifiedEmail"`
+	// groupsClaimのクレームに、いずれかのグループを持つユーザーのみを許可する
+	// groupsClaimは"realm_access.roles"のようにドットで区切って指定でき、省略した場合は"groups"
+	AllowedGroups []string `json:"allowedGroups"`
+	GroupsClaim   string   `json:"groupsClaim"`
+
+	// パスとメソッドごとのアクセス制御の規則。先頭から順に評価し、最初に一致した規則で許可するかを決める
+	Rules []RuleSchema `json:"rules"`
+}
+
+type RuleSchema struct {
+	// pathとpathRegexのどちらか一方を指定する。どちらもmatchPathを除いたUpstream内のパスに対して照合する
+	// pathはグロブで、*は/を含む任意の文字列に、?は任意の1文字に一致する
+	Path      string `json:"path"`
+	PathRegex string `json:"pathRegex"`
+
+	// 省略した場合は全てのメソッドに一致する
+	Methods []string `json:"methods"`
+
+	// trueの場合、一致したリクエストを常に拒否する
+	Deny bool `json:"deny"`
+
+	// いずれかのグループに属している必要がある
+	Groups []string `json:"groups"`
+	// 全てのスコープが許可されている必要がある
+	Scopes []string `json:"scopes"`
+	// クレームのパスから必要な値への対応
+	Claims map[string]string `json:"claims"`
 }
 
 // 期間をそのままJSONに記述できるようにするためには、encoding/jsonの要求するインターフェースをみたす型である必要があるため
@@ -55,6 +99,49 @@ func (s *ConfigSchema) Validate() error {
 		errMessages = append(errMessages, err.Error())
 	}
 
+	for _, u := range s.Servers {
+		if u.MaxAuthAge != nil && time.Duration(*u.MaxAuthAge) < time.Second {
+			errMessages = append(errMessages, fmt.Sprintf("error: upstream maxAuthAge must be at least 1s: %s", u.ID))
+		}
+	}
+
+	if err := validateAllowlists(s.Servers); err != nil {
+		errMessages = append(errMessages, err.Error())
+	}
+
+	for _, u := range s.Servers {
+		if err := validateRules(u); err != nil {
+			errMessages = append(errMessages, err.Error())
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
+func validateAllowlists(servers []ServerSchema) error {
+	errMessages := make([]string, 0)
+
+	for _, s := range servers {
+		for _, email := range s.AllowedEmails {
+			if !strings.Contains(email, "@") {
+				errMessages = append(errMessages, fmt.Sprintf("error: upstream allowedEmails has invalid email: %s", email))
+			}
+		}
+		for _, domain := range s.AllowedEmailDomains {
+			if domain == "" || strings.Contains(domain, "@") {
+				errMessages = append(errMessages, fmt.Sprintf("error: upstream allowedEmailDomains has invalid domain: %s", domain))
+			}
+		}
+		for _, group := range s.AllowedGroups {
+			if group == "" {
+				errMessages = append(errMessages, fmt.Sprintf("error: upstream allowedGroups has empty group: %s", s.ID))
+			}
+		}
+	}
+
 	if len(errMessages) > 0 {
 		return errors.New(strings.Join(errMessages, "\n"))
 	}
@@ -81,6 +168,31 @@ func isValidURL(toTest string) bool {
 	return err == nil && u.Scheme != "" && u.Host != ""
 }
 
+const defaultGroupsClaim string = "groups"
+
+// メールアドレスとドメインは大文字と小文字を区別しないため、小文字に揃えておく
+func createAllowlist(server ServerSchema) identity.Allowlist {
+	emails := make([]string, 0)
+	for _, email := range server.AllowedEmails {
+		emails = append(emails, strings.ToLower(email))
+	}
+	domains := make([]string, 0)
+	for _, domain := range server.AllowedEmailDomains {
+		domains = append(domains, strings.ToLower(domain))
+	}
+	groupsClaim := server.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = defaultGroupsClaim
+	}
+	return identity.Allowlist{
+		Emails:               emails,
+		EmailDomains:         domains,
+		AllowUnverifiedEmail: server.AllowUnverifiedEmail,
+		GroupsClaim:          groupsClaim,
+		Groups:               server.AllowedGroups,
+	}
+}
+
 func (s *ConfigSchema) CreateConfig() Config {
 	servers := make([]Server, 0)
 	for _, server := range s.Servers {
@@ -92,11 +204,21 @@ func (s *ConfigSchema) CreateConfig() Config {
 			t := 30 * time.Second
 			timeout = (*Duration)(&t)
 		}
+		requirement := identity.Requirement{
+			ACRValues: server.ACRValues,
+			AMR:       server.AMR,
+		}
+		if server.MaxAuthAge != nil {
+			requirement.MaxAuthAge = time.Duration(*server.MaxAuthAge)
+		}
 		servers = append(servers, Server{
 			ID:          server.ID,
 			URL:         baseURL,
 			MatchPrefix: server.MatchPrefix,
 			Timeout:     timeout,
+			Requirement: requirement,
+			Allowlist:   createAllowlist(server),
+			Rules:       createRules(server),
 		})
 	}
 
@@ -19,6 +19,11 @@ func fixUpstreamPath(server Server, r *http.Request) {
 	r.URL.Scheme = server.URL.Scheme
 	r.URL.Host = server.URL.Host
 	r.URL.Path = server.URL.Path + strings.TrimPrefix(originalPath, server.MatchPrefix)
+	// 規則を持つUpstreamには、規則と照合したデコード後のパスをそのまま送るため、元のエンコードは使わない
+	// 規則を持たないUpstreamには、従来通り元のエンコードを保ったまま送る
+	if len(server.Rules) > 0 {
+		r.URL.RawPath = ""
+	}
 }
 
 func modifyResponse(server Server) func(response *http.Response) error {
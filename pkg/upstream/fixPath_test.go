@@ -0,0 +1,37 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestFixUpstreamPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverURL string
+		rules     []Rule
+		target    string
+		want      string
+	}{
+		{"root upstream", "http://localhost:3000", nil, "/myservice/reports/a", "http://localhost:3000/reports/a"},
+		{"upstream with path", "http://localhost:3000/base", nil, "/myservice/reports/a", "http://localhost:3000/base/reports/a"},
+		{"encoded character", "http://localhost:3000", nil, "/myservice/a%20b", "http://localhost:3000/a%20b"},
+		{"original encoding without rules", "http://localhost:3000/myservice", nil, "/myservice/a%2Cb", "http://localhost:3000/myservice/a%2Cb"},
+		{"encoded slash without rules", "http://localhost:3000/myservice", nil, "/myservice/a%2Fb", "http://localhost:3000/myservice/a%2Fb"},
+		{"same encoding as checked path with rules", "http://localhost:3000/myservice", []Rule{{}}, "/myservice/a%2Cb", "http://localhost:3000/myservice/a,b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverURL, _ := url.Parse(tt.serverURL)
+			server := Server{ID: "test", URL: serverURL, MatchPrefix: "/myservice", Rules: tt.rules}
+			r := httptest.NewRequest(http.MethodGet, "http://proxy.example.com"+tt.target, nil)
+			fixUpstreamPath(server, r)
+			if got := r.URL.String(); got != tt.want {
+				t.Errorf("fixUpstreamPath() URL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
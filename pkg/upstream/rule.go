@@ -0,0 +1,106 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
+)
+
+// Upstream内のパスとメソッドごとのアクセス制御の規則
+// 規則は先頭から順に評価し、最初に一致した規則のみで許可するかを決める。どの規則にも一致しない場合は許可する
+type Rule struct {
+	// 空の場合は全てのメソッドに一致する
+	Methods []string
+	Path    *regexp.Regexp
+
+	// trueの場合、一致したリクエストを常に拒否する
+	Deny bool
+
+	// いずれかのグループに属している必要がある。グループはUpstreamのgroupsClaimから読む
+	Groups []string
+	// 全てのスコープが許可されている必要がある
+	Scopes []string
+	// クレームのパスから値への対応。全てのクレームが値と一致するか、配列の場合は値を含む必要がある
+	Claims map[string]string
+}
+
+func (rule Rule) matches(method string, path string) bool {
+	if len(rule.Methods) > 0 && !containsMethod(rule.Methods, method) {
+		return false
+	}
+	return rule.Path.MatchString(path)
+}
+
+func (rule Rule) check(ident *identity.Identity, claims map[string]any, groupsClaim string) error {
+	if rule.Deny {
+		return errors.New("error: request is denied by rule")
+	}
+
+	errMessages := make([]string, 0)
+	if len(rule.Groups) > 0 {
+		groups := identity.GetStrings(claims, groupsClaim)
+		if !slices.ContainsFunc(groups, func(group string) bool { return slices.Contains(rule.Groups, group) }) {
+			errMessages = append(errMessages, fmt.Sprintf("error: user does not belong to groups required by rule: %v", groups))
+		}
+	}
+	for _, scope := range rule.Scopes {
+		if !slices.Contains(ident.Scopes, scope) {
+			errMessages = append(errMessages, fmt.Sprintf("error: scope required by rule is not granted: %s", scope))
+		}
+	}
+	for path, want := range rule.Claims {
+		if !slices.Contains(getClaimValues(claims, path), want) {
+			errMessages = append(errMessages, fmt.Sprintf("error: claim required by rule does not match: %s", path))
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
+// HEADはGETと同じリソースを返すため、GETの規則はHEADにも適用する
+func containsMethod(methods []string, method string) bool {
+	return slices.Contains(methods, method) || (method == http.MethodHead && slices.Contains(methods, http.MethodGet))
+}
+
+// 文字列以外のクレームも設定ファイルの文字列と比較できるように、文字列に変換する
+func getClaimValues(claims map[string]any, path string) []string {
+	value, found := identity.Lookup(claims, path)
+	if !found {
+		return nil
+	}
+	values := make([]string, 0)
+	items, isArray := value.([]any)
+	if !isArray {
+		items = []any{value}
+	}
+	for _, item := range items {
+		values = append(values, fmt.Sprint(item))
+	}
+	return values
+}
+
+// 最初に一致した規則を返す
+func findRule(rules []Rule, method string, path string) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.matches(method, path) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// グロブの*は/を含む任意の文字列に、?は任意の1文字に一致する
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+	pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+	return regexp.Compile("^" + pattern + "$")
+}
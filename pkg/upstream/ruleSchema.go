@@ -0,0 +1,106 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var methodPattern *regexp.Regexp = regexp.MustCompile(`^[A-Z]+$`)
+
+func validateRules(server ServerSchema) error {
+	errMessages := make([]string, 0)
+
+	for i, rule := range server.Rules {
+		// 設定ファイルの規則を人が探しやすいように、1から数える
+		name := fmt.Sprintf("upstream %s rule %d", server.ID, i+1)
+		if (rule.Path == "") == (rule.PathRegex == "") {
+			errMessages = append(errMessages, fmt.Sprintf("error: %s requires either path or pathRegex", name))
+		}
+		if rule.PathRegex != "" {
+			if _, err := regexp.Compile(rule.PathRegex); err != nil {
+				errMessages = append(errMessages, fmt.Sprintf("error: %s has invalid pathRegex: %s", name, err.Error()))
+			}
+		}
+		for _, method := range rule.Methods {
+			if !methodPattern.MatchString(method) {
+				errMessages = append(errMessages, fmt.Sprintf("error: %s has invalid method: %s", name, method))
+			}
+		}
+		if rule.Deny && (len(rule.Groups) > 0 || len(rule.Scopes) > 0 || len(rule.Claims) > 0) {
+			errMessages = append(errMessages, fmt.Sprintf("error: %s cannot have requirements with deny", name))
+		}
+	}
+
+	// 到達できない規則は、設定者の意図と異なる動作をしている可能性が高いため、エラーとする
+	if len(errMessages) == 0 {
+		for j := range server.Rules {
+			for i := 0; i < j; i++ {
+				if coversRule(server.Rules[i], server.Rules[j]) {
+					errMessages = append(errMessages, fmt.Sprintf("error: upstream %s rule %d is unreachable because rule %d matches all of its requests", server.ID, j+1, i+1))
+					break
+				}
+			}
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
+// earlierに一致しないリクエストがlaterに一致しえない場合にtrueを返す
+// 正規表現の包含関係は一般には判定できないため、確実に分かる場合のみtrueを返す
+func coversRule(earlier RuleSchema, later RuleSchema) bool {
+	if len(earlier.Methods) > 0 {
+		if len(later.Methods) == 0 {
+			return false
+		}
+		for _, method := range later.Methods {
+			if !containsMethod(earlier.Methods, method) {
+				return false
+			}
+		}
+	}
+
+	if earlier.PathRegex != "" {
+		return earlier.PathRegex == later.PathRegex
+	}
+	// パスは必ず/で始まるため、*と/*は全てのパスに一致する
+	if trimmed := strings.Trim(earlier.Path, "*"); trimmed == "" || trimmed == "/" {
+		return true
+	}
+	if later.PathRegex != "" || strings.Contains(earlier.Path, "?") {
+		return false
+	}
+	// earlierの*は任意の文字列に一致するため、laterのグロブを文字列として照合できれば、laterの*や?に何が入ってもearlierに一致する
+	earlierPattern, err := compileGlob(earlier.Path)
+	if err != nil {
+		return false
+	}
+	return earlierPattern.MatchString(later.Path)
+}
+
+// Validateにてエラーチェックは終わっているため、コンパイルのエラーは起きない
+func createRules(server ServerSchema) []Rule {
+	rules := make([]Rule, 0)
+	for _, rule := range server.Rules {
+		var path *regexp.Regexp
+		if rule.PathRegex != "" {
+			path = regexp.MustCompile(rule.PathRegex)
+		} else {
+			path, _ = compileGlob(rule.Path)
+		}
+		rules = append(rules, Rule{
+			Methods: rule.Methods,
+			Path:    path,
+			Deny:    rule.Deny,
+			Groups:  rule.Groups,
+			Scopes:  rule.Scopes,
+			Claims:  rule.Claims,
+		})
+	}
+	return rules
+}
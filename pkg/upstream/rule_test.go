@@ -0,0 +1,171 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{"/reports/*", "/reports/a", true},
+		{"/reports/*", "/reports/a/b", true},
+		{"/reports/*", "/reports", false},
+		{"/reports/*", "/admin/reports/a", false},
+		{"/a?c", "/abc", true},
+		{"/a?c", "/ac", false},
+		{"/a.c", "/abc", false},
+		{"/a.c", "/a.c", true},
+		{"/api/(v1)", "/api/(v1)", true},
+		{"/api/(v1)", "/api/v1", false},
+		{"*", "/anything", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			pattern, err := compileGlob(tt.glob)
+			if err != nil {
+				t.Fatalf("compileGlob(%q) error = %v", tt.glob, err)
+			}
+			if got := pattern.MatchString(tt.path); got != tt.want {
+				t.Errorf("compileGlob(%q).MatchString(%q) = %v, want %v", tt.glob, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindRule(t *testing.T) {
+	schema := ServerSchema{
+		ID: "test",
+		Rules: []RuleSchema{
+			{Path: "/reports/*", Methods: []string{"GET"}},
+			{Path: "/admin/*", Deny: true},
+			{PathRegex: "^/api/v[0-9]+/"},
+		},
+	}
+	rules := createRules(schema)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"GET matches GET rule", http.MethodGet, "/reports/a", 0},
+		{"HEAD matches GET rule", http.MethodHead, "/reports/a", 0},
+		{"POST does not match GET rule", http.MethodPost, "/reports/a", -1},
+		{"rule without methods", http.MethodPost, "/admin/x", 1},
+		{"regex rule", http.MethodGet, "/api/v2/x", 2},
+		{"no rule", http.MethodGet, "/other", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, found := findRule(rules, tt.method, tt.path)
+			if tt.want < 0 {
+				if found {
+					t.Errorf("findRule(%s, %s) found %v, want none", tt.method, tt.path, rule.Path)
+				}
+				return
+			}
+			if !found || rule.Path != rules[tt.want].Path {
+				t.Errorf("findRule(%s, %s) = %v, %v, want rule %d", tt.method, tt.path, rule.Path, found, tt.want+1)
+			}
+		})
+	}
+}
+
+func TestCoversRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		earlier RuleSchema
+		later   RuleSchema
+		want    bool
+	}{
+		{"wildcard covers all", RuleSchema{Path: "/*"}, RuleSchema{PathRegex: "^/x"}, true},
+		{"star covers all", RuleSchema{Path: "*"}, RuleSchema{Path: "/x"}, true},
+		{"prefix covers narrower glob", RuleSchema{Path: "/admin/*"}, RuleSchema{Path: "/admin/users/*"}, true},
+		{"prefix does not cover other prefix", RuleSchema{Path: "/admin/*"}, RuleSchema{Path: "/reports/*"}, false},
+		{"narrower does not cover wider", RuleSchema{Path: "/admin/users/*"}, RuleSchema{Path: "/admin/*"}, false},
+		{"question mark is not compared", RuleSchema{Path: "/a?"}, RuleSchema{Path: "/ab"}, false},
+		{"same regex", RuleSchema{PathRegex: "^/x"}, RuleSchema{PathRegex: "^/x"}, true},
+		{"different regex", RuleSchema{PathRegex: "^/x"}, RuleSchema{PathRegex: "^/x/y"}, false},
+		{"glob does not cover regex", RuleSchema{Path: "/x/*"}, RuleSchema{PathRegex: "^/x/"}, false},
+		{"methods cover subset", RuleSchema{Path: "/*", Methods: []string{"GET", "POST"}}, RuleSchema{Path: "/x", Methods: []string{"GET"}}, true},
+		{"methods do not cover superset", RuleSchema{Path: "/*", Methods: []string{"GET"}}, RuleSchema{Path: "/x", Methods: []string{"GET", "POST"}}, false},
+		{"methods do not cover any method", RuleSchema{Path: "/*", Methods: []string{"GET"}}, RuleSchema{Path: "/x"}, false},
+		{"GET covers HEAD", RuleSchema{Path: "/*", Methods: []string{"GET"}}, RuleSchema{Path: "/x", Methods: []string{"HEAD"}}, true},
+		{"HEAD does not cover GET", RuleSchema{Path: "/*", Methods: []string{"HEAD"}}, RuleSchema{Path: "/x", Methods: []string{"GET"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := coversRule(tt.earlier, tt.later); got != tt.want {
+				t.Errorf("coversRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []RuleSchema
+		wantErr bool
+	}{
+		{"valid", []RuleSchema{{Path: "/admin/*", Groups: []string{"admins"}}, {Path: "/*", Methods: []string{"GET"}}}, false},
+		{"no path", []RuleSchema{{Methods: []string{"GET"}}}, true},
+		{"both path and regex", []RuleSchema{{Path: "/x", PathRegex: "^/x"}}, true},
+		{"invalid regex", []RuleSchema{{PathRegex: "("}}, true},
+		{"lower case method", []RuleSchema{{Path: "/x", Methods: []string{"get"}}}, true},
+		{"deny with requirements", []RuleSchema{{Path: "/x", Deny: true, Groups: []string{"admins"}}}, true},
+		{"unreachable rule", []RuleSchema{{Path: "/*"}, {Path: "/admin/*"}}, true},
+		{"unreachable HEAD rule", []RuleSchema{{Path: "/*", Methods: []string{"GET"}}, {Path: "/x", Methods: []string{"HEAD"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRules(ServerSchema{ID: "test", Rules: tt.rules})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckCanonicalPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		wantErr bool
+	}{
+		{"plain", "/reports/a", false},
+		{"trailing slash", "/reports/", false},
+		{"root", "/", false},
+		{"encoded character", "/reports/a%20b", false},
+		{"dot dot", "/reports/../admin/x", true},
+		{"encoded dot dot", "/reports/%2e%2e/admin/x", true},
+		{"upper case encoded dot dot", "/reports/%2E%2E/admin/x", true},
+		{"dot", "/./admin/x", true},
+		{"double slash", "//admin/x", true},
+		{"inner double slash", "/reports//admin/x", true},
+		{"encoded slash", "/reports%2Fadmin/x", true},
+		{"lower case encoded slash", "/reports%2fadmin/x", true},
+		{"encoded backslash", "/reports%5C..%5Cadmin/x", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// //admin/xをホストとして解釈させないため、ホストを付けて解析する
+			r := httptest.NewRequest(http.MethodGet, "http://proxy.example.com"+tt.target, nil)
+			err := checkCanonicalPath(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkCanonicalPath(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,23 +1,59 @@
 package upstream
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
 	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
 )
 
-func NewRouter(config Config) *chi.Mux {
+// stepUpHandlerは、ユーザーの認証の強度がUpstreamの要求を満たさないときに呼ばれる
+// 要求はidentity.RequirementKeyでContextに格納される
+// forbiddenHandlerは、ユーザーがUpstreamの許可リストに含まれないときに呼ばれる
+func NewRouter(config Config, stepUpHandler http.Handler, forbiddenHandler http.Handler) *chi.Mux {
 	r := chi.NewRouter()
 	for _, server := range config.Servers {
 		proxy := setupReverseProxy(server)
 		r.Route(server.MatchPrefix, func(r chi.Router) {
 			r.HandleFunc("/*", func(w http.ResponseWriter, r *http.Request) {
 				logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+				ident := r.Context().Value(identity.Key{}).(*identity.Identity)
+				// 許可されないユーザーは再認証しても許可されないため、Step-upよりも先に確認する
+				if !server.Allowlist.IsEmpty() {
+					if err := checkAllowlist(server, ident); err != nil {
+						logger.Warn().Err(err).Msg(fmt.Sprintf("User is not allowed to access upstream: %s.", server.ID))
+						forbiddenHandler.ServeHTTP(w, r)
+						return
+					}
+				}
+				if len(server.Rules) > 0 {
+					// 規則と照合したパスとUpstreamに送るパスの解釈がずれると規則を迂回できるため、正規化されていないパスは拒否する
+					if err := checkCanonicalPath(r); err != nil {
+						logger.Warn().Err(err).Msg(fmt.Sprintf("Request path is not canonical for upstream: %s.", server.ID))
+						i18n.Error(w, r, "error.invalidPath", http.StatusBadRequest)
+						return
+					}
+					if err := checkRules(server, ident, r); err != nil {
+						logger.Warn().Err(err).Msg(fmt.Sprintf("Request is not allowed by rules of upstream: %s.", server.ID))
+						forbiddenHandler.ServeHTTP(w, r)
+						return
+					}
+				}
+				if err := server.Requirement.Check(ident.IDTokenClaims, time.Now()); err != nil {
+					logger.Info().Err(err).Msg(fmt.Sprintf("Step-up authentication is required for upstream: %s.", server.ID))
+					ctx := context.WithValue(r.Context(), identity.RequirementKey{}, server.Requirement)
+					stepUpHandler.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
 				logger.Info().Msg(fmt.Sprintf("Proxied request to upstream: %s.", server.ID))
 				proxy.ServeHTTP(w, r)
 			})
@@ -26,6 +62,47 @@ func NewRouter(config Config) *chi.Mux {
 	return r
 }
 
+func checkAllowlist(server Server, ident *identity.Identity) error {
+	claims, err := ident.AllClaims()
+	if err != nil {
+		return err
+	}
+	return server.Allowlist.Check(claims)
+}
+
+// %2eや..はデコード後のパスで、//はそのまま検出できる。%2fと%5cはデコードすると区切りと見分けられないため、エンコードされた形で検出する
+func checkCanonicalPath(r *http.Request) error {
+	rawPath := strings.ToLower(r.URL.EscapedPath())
+	if strings.Contains(rawPath, "%2f") || strings.Contains(rawPath, "%5c") || strings.Contains(r.URL.Path, "\\") {
+		return fmt.Errorf("error: path contains encoded slash or backslash: %s", r.URL.EscapedPath())
+	}
+	cleaned := path.Clean(r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	if cleaned != r.URL.Path {
+		return fmt.Errorf("error: path is not canonical: %s", r.URL.EscapedPath())
+	}
+	return nil
+}
+
+// 規則のパスはmatchPathを除いたUpstream内のパスに対して照合する
+func checkRules(server Server, ident *identity.Identity, r *http.Request) error {
+	path := strings.TrimPrefix(r.URL.Path, server.MatchPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	rule, found := findRule(server.Rules, r.Method, path)
+	if !found {
+		return nil
+	}
+	claims, err := ident.AllClaims()
+	if err != nil {
+		return err
+	}
+	return rule.check(ident, claims, server.Allowlist.GroupsClaim)
+}
+
 func setupReverseProxy(server Server) *httputil.ReverseProxy {
 	proxy := httputil.NewSingleHostReverseProxy(server.URL)
 	proxy.Director = modifyRequest(server)
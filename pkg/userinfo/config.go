@@ -0,0 +1,5 @@
+package userinfo
+
+type Config struct {
+	Claims []string
+}
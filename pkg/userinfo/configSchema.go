@@ -0,0 +1,46 @@
+package userinfo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type ConfigSchema struct {
+	// /oauth2/userinfoで返すクレームの名前。"realm_access.roles"のようにドットで区切ってネストしたクレームも指定できる
+	// 省略した場合はsub、email、nameおよびgroupsを返す
+	Claims []string `json:"claims"`
+}
+
+var defaultClaims []string = []string{"sub", "email", "name", "groups"}
+
+// プロキシ自体が付与する値と衝突するため、クレームの名前として使えない
+var reservedKeys []string = []string{providerIDKey, expiresAtKey}
+
+func (s *ConfigSchema) Validate() error {
+	errMessages := make([]string, 0)
+
+	for _, claim := range s.Claims {
+		if claim == "" {
+			errMessages = append(errMessages, "error: userinfo claim name is empty")
+		} else if slices.Contains(reservedKeys, claim) {
+			errMessages = append(errMessages, fmt.Sprintf("error: userinfo claim name is reserved: %s", claim))
+		}
+	}
+
+	if len(errMessages) > 0 {
+		return errors.New(strings.Join(errMessages, "\n"))
+	}
+	return nil
+}
+
+func (s *ConfigSchema) CreateConfig() Config {
+	claims := s.Claims
+	if len(claims) == 0 {
+		claims = defaultClaims
+	}
+	return Config{
+		Claims: claims,
+	}
+}
@@ -0,0 +1,81 @@
+package userinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/bearer"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/i18n"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/identity"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/log"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/login"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/session"
+	"github.com/wolfmagnate/mini-oauth2-proxy/pkg/sessionid"
+)
+
+const path string = "/oauth2/userinfo"
+
+const (
+	providerIDKey string = "providerID"
+	expiresAtKey  string = "expiresAt"
+)
+
+// Upstreamを経由せずに、フロントエンドがログイン中のユーザーを知るためのエンドポイントを追加する
+func AddEndpoint(r *chi.Mux, config Config) {
+	r.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(log.Key{}).(*zerolog.Logger)
+		w.Header().Set("Cache-Control", "no-store")
+
+		isLogin := r.Context().Value(login.Key{}).(bool)
+		if !isLogin {
+			logger.Debug().Msg("Userinfo was requested without login")
+			writeJSON(w, http.StatusUnauthorized, map[string]any{
+				"error":   "unauthorized",
+				"message": i18n.Message(r, "error.unauthorized"),
+			})
+			return
+		}
+
+		ident := r.Context().Value(identity.Key{}).(*identity.Identity)
+		claims, err := ident.AllClaims()
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to read claims of the current user")
+			i18n.Error(w, r, "error.internal", http.StatusInternalServerError)
+			return
+		}
+
+		response := make(map[string]any)
+		for _, name := range config.Claims {
+			if value, found := identity.Lookup(claims, name); found {
+				response[name] = value
+			}
+		}
+		response[providerIDKey] = ident.ProviderID
+		if expiresAt, err := getExpiresAt(r); err == nil {
+			response[expiresAtKey] = expiresAt.UTC().Format(time.RFC3339)
+		} else {
+			logger.Warn().Err(err).Msg("Failed to get session expiry")
+		}
+
+		logger.Debug().Msg("Returning userinfo of the current user")
+		writeJSON(w, http.StatusOK, response)
+	})
+}
+
+// ベアラートークンにはセッションが無いため、トークン自体の有効期限を返す
+func getExpiresAt(r *http.Request) (time.Time, error) {
+	if token, ok := r.Context().Value(bearer.Key{}).(bearer.Token); ok {
+		return token.IDToken.Expiry, nil
+	}
+	id := r.Context().Value(sessionid.Key{}).(sessionid.ID)
+	return session.GetSessionExpiry(id)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}